@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	otelprom "go.opentelemetry.io/otel/exporters/prometheus"
+
+	"github.com/moritzloewenstein/otel-docker-logging-driver/internal/driver"
+	"github.com/moritzloewenstein/otel-docker-logging-driver/internal/otelx"
+)
+
+// Export failures for longer than this make /healthz fail; any failure since the last
+// success makes /readyz fail.
+const healthGracePeriod = 5 * time.Minute
+
+// newPrometheusReader returns a metric reader serving the driver's metrics from its
+// own registry, and the handler exposing them in the Prometheus text format.
+func newPrometheusReader() (*otelprom.Exporter, http.Handler, error) {
+	reg := prometheus.NewRegistry()
+	exp, err := otelprom.New(otelprom.WithRegisterer(reg))
+	if err != nil {
+		return nil, nil, fmt.Errorf("create prometheus exporter: %w", err)
+	}
+	return exp, promhttp.HandlerFor(reg, promhttp.HandlerOpts{}), nil
+}
+
+// newHTTPServer serves /metrics, /healthz and /readyz on addr.
+func newHTTPServer(addr string, metricsHandler http.Handler, metrics *otelx.Metrics, drv *driver.Driver) (*http.Server, net.Listener, error) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metricsHandler)
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		if _, failing := metrics.ExportState(); !failing.IsZero() && time.Since(failing) > healthGracePeriod {
+			http.Error(w, fmt.Sprintf("exports failing since %s", failing.Format(time.RFC3339)), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, _ *http.Request) {
+		if drv.Status().Degraded {
+			http.Error(w, "degraded: invalid configuration", http.StatusServiceUnavailable)
+			return
+		}
+		if _, failing := metrics.ExportState(); !failing.IsZero() {
+			http.Error(w, fmt.Sprintf("exports failing since %s", failing.Format(time.RFC3339)), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("listen on %s: %w", addr, err)
+	}
+	return &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}, l, nil
+}
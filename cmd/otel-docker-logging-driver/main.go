@@ -2,14 +2,15 @@ package main
 
 import (
 	"context"
-	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
-	"time"
 
 	"github.com/docker/go-plugins-helpers/sdk"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
 
 	"github.com/moritzloewenstein/otel-docker-logging-driver/internal/config"
 	"github.com/moritzloewenstein/otel-docker-logging-driver/internal/driver"
@@ -18,28 +19,85 @@ import (
 
 func main() {
 	cfg := config.FromEnv()
+	slog.SetDefault(newLogger(cfg))
 
-	exp, provider, err := otelx.SetupProvider(context.Background(), cfg)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "failed to setup otlp exporter: %v\n", err)
+	// Refuse to start on a broken configuration, unless degraded mode was requested;
+	// the problems are then reported by the status endpoint.
+	problems := cfg.Validate()
+	for _, p := range problems {
+		slog.Error("invalid configuration", "env", p.Env, "error", p.Message)
+	}
+	if len(problems) > 0 && !cfg.Degraded {
 		os.Exit(1)
 	}
-	defer func() {
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-		_ = provider.Shutdown(ctx)
-		_ = exp.Shutdown(ctx)
-	}()
 
-	drv := driver.New(cfg, provider)
+	// The driver's own metrics go to the same collector as the logs, and can also be
+	// scraped from the optional HTTP listener.
+	var readers []sdkmetric.Reader
+	var metricsHandler http.Handler = http.NotFoundHandler()
+	if cfg.MetricsAddr != "" {
+		reader, h, err := newPrometheusReader()
+		if err != nil {
+			slog.Error("failed to setup metrics", "error", err)
+			os.Exit(1)
+		}
+		readers, metricsHandler = append(readers, reader), h
+	}
+	var metrics *otelx.Metrics
+	mp, err := otelx.NewMeterProvider(context.Background(), cfg, readers...)
+	if err == nil {
+		metrics, err = otelx.NewMetrics(mp)
+	}
+	if err != nil {
+		slog.Error("failed to setup metrics", "error", err)
+		if !cfg.Degraded {
+			os.Exit(1)
+		}
+		metrics = otelx.NoopMetrics()
+	}
+
+	// Containers without exporter overrides share the plugin-level exporter, which is
+	// created up front so a broken configuration fails at startup. In degraded mode
+	// containers are accepted regardless, without exporting their records.
+	pool := otelx.NewPool(metrics.NewExporter, otelx.NewBatchProcessor)
+	if _, err := pool.Acquire(context.Background(), cfg, nil); err != nil {
+		slog.Error("failed to setup otlp exporter", "endpoint", cfg.Endpoint, "error", err)
+		if !cfg.Degraded {
+			os.Exit(1)
+		}
+	}
+	// Batches left in the export queues of exporters no container uses any more are
+	// delivered in the background.
+	replayCtx, stopReplay := context.WithCancel(context.Background())
+	go pool.ReplayQueues(replayCtx, cfg)
+	drv := driver.New(cfg, pool, metrics)
+
+	var srv *http.Server
+	if cfg.MetricsAddr != "" {
+		var l net.Listener
+		srv, l, err = newHTTPServer(cfg.MetricsAddr, metricsHandler, metrics, drv)
+		if err != nil {
+			slog.Error("failed to start metrics listener", "addr", cfg.MetricsAddr, "error", err)
+			if !cfg.Degraded {
+				os.Exit(1)
+			}
+		} else {
+			go func() {
+				if err := srv.Serve(l); err != nil && err != http.ErrServerClosed {
+					slog.Error("metrics listener failed", "addr", cfg.MetricsAddr, "error", err)
+				}
+			}()
+		}
+	}
 
 	h := sdk.NewHandler(`{"Implements": ["LoggingDriver"]}`)
 	driver.RegisterHandlers(&h, drv)
 
-	// Graceful shutdown of the HTTP server on unix socket is handled by Docker.
+	// The plugin socket is served until the process exits, so Docker can still stop
+	// containers while the plugin shuts down.
 	go func() {
 		if err := h.ServeUnix("otel-logs", 0); err != nil && err != http.ErrServerClosed {
-			fmt.Fprintf(os.Stderr, "serve error: %v\n", err)
+			slog.Error("failed to serve plugin socket", "error", err)
 			os.Exit(1)
 		}
 	}()
@@ -48,4 +106,45 @@ func main() {
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 	<-sigCh
+
+	// Drain every container, flush the exporters and the driver's own metrics, and stop
+	// the metrics listener, all within one overall timeout; records not exported by
+	// then are abandoned.
+	slog.Info("shutting down", "timeout", cfg.ShutdownTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	stopReplay()
+	abandoned, drainErr := drv.Shutdown(ctx)
+	if err := pool.Shutdown(ctx); err != nil {
+		slog.Error("cannot flush exporters", "error", err)
+	}
+	if mp != nil {
+		if err := mp.Shutdown(ctx); err != nil {
+			slog.Error("cannot flush metrics", "error", err)
+		}
+	}
+	if srv != nil {
+		_ = srv.Shutdown(ctx)
+	}
+	// Records discarded before reaching an exporter, and those the exporters neither
+	// sent nor queued on disk
+	if n := abandoned + pool.Pending(); n > 0 || drainErr != nil {
+		slog.Warn("shutdown incomplete, records abandoned", "count", n, "timeout", cfg.ShutdownTimeout)
+	} else {
+		slog.Info("shutdown complete")
+	}
+}
+
+// newLogger returns the plugin's own logger, writing to stderr where Docker collects
+// it into the daemon log. Invalid settings fall back to info and text.
+func newLogger(cfg config.Config) *slog.Logger {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.LogLevel)); err != nil {
+		level = slog.LevelInfo
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	if cfg.LogFormat == "json" {
+		return slog.New(slog.NewJSONHandler(os.Stderr, opts))
+	}
+	return slog.New(slog.NewTextHandler(os.Stderr, opts))
 }
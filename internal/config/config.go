@@ -1,8 +1,13 @@
 package config
 
 import (
+	"fmt"
 	"os"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/docker/go-units"
 )
 
 type Config struct {
@@ -16,19 +21,161 @@ type Config struct {
 	Headers map[string]string
 	// Compression: "gzip" or ""
 	Compression string
+	// Maximum duration of a single export request; zero uses the exporter default
+	Timeout time.Duration
+	// Retry of failed export requests with exponential backoff; zero intervals use the
+	// exporter defaults
+	RetryEnabled         bool
+	RetryInitialInterval time.Duration
+	RetryMaxInterval     time.Duration
+	RetryMaxElapsedTime  time.Duration
+	// Batch log record processor settings; zero values use the SDK defaults
+	BatchMaxQueueSize       int
+	BatchScheduleDelay      time.Duration
+	BatchMaxExportBatchSize int
+	BatchExportTimeout      time.Duration
+	// Self-telemetry metrics sent to the same collector: "otlp" or "none", and the
+	// export interval
+	MetricsExporter string
+	MetricsInterval time.Duration
+	// Listen address of the HTTP server for Prometheus scraping and health checks;
+	// empty disables it
+	MetricsAddr string
+	// Optional TLS files (PEM): CA bundle for the server, client certificate and key for mTLS
+	Certificate       string
+	ClientCertificate string
+	ClientKey         string
+	// Directory for the local per-container log store backing `docker logs`; empty disables it
+	LogDir string
+	// Store files of containers not logging for this long are removed from LogDir
+	LogRetention time.Duration
+	// Upper bound of LogDir; the stores of the containers that logged least recently
+	// are removed beyond it
+	LogMaxSize int64
+	// Record attribute schema: "legacy" (docker.*), "semconv" or "both"
+	AttributeSchema string
+	// Directory for the durable on-disk export queue; empty keeps records in memory only
+	QueueDir string
+	// Upper bound of the on-disk queue; the oldest records are evicted beyond it
+	QueueMaxSize int64
+	// Size of a single queue segment file
+	QueueSegmentSize int64
+	// Queue fsync policy: "always", "interval" or "never"
+	QueueFsync string
+	// Upper bound for StopLogging to drain a container's FIFO and flush its records
+	StopTimeout time.Duration
+	// Upper bound for draining every container and flushing the exporters when the
+	// plugin is stopped
+	ShutdownTimeout time.Duration
+	// Start even if Validate reports problems; they are then served by the status endpoint
+	Degraded bool
+	// The plugin's own log: level "debug", "info", "warn" or "error", format "text" or "json"
+	LogLevel  string
+	LogFormat string
+
+	// Variables the settings were read from, and values FromEnv could not parse
+	env      map[string]string
+	problems []Problem
 }
 
 func FromEnv() Config {
+	r := envReader{names: map[string]string{}}
 	c := Config{
-		Endpoint:    getenvDefault("OTEL_EXPORTER_OTLP_LOGS_ENDPOINT", getenvDefault("OTEL_EXPORTER_OTLP_ENDPOINT", "http://localhost:4317")),
-		Protocol:    normalizeProtocol(getenvDefault("OTEL_EXPORTER_OTLP_LOGS_PROTOCOL", os.Getenv("OTEL_EXPORTER_OTLP_PROTOCOL"))),
-		Insecure:    strings.EqualFold(os.Getenv("OTEL_EXPORTER_OTLP_LOGS_INSECURE"), "true") || strings.EqualFold(os.Getenv("OTEL_EXPORTER_OTLP_INSECURE"), "true"),
-		Headers:     parseHeaders(getenvDefault("OTEL_EXPORTER_OTLP_LOGS_HEADERS", os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"))),
-		Compression: os.Getenv("OTEL_EXPORTER_OTLP_LOGS_COMPRESSION"),
+		// Variables prefer LOGS_* and fall back to the generic OTLP_* ones
+		Endpoint:    r.get("Endpoint", "OTEL_EXPORTER_OTLP_LOGS_ENDPOINT", "OTEL_EXPORTER_OTLP_ENDPOINT"),
+		Protocol:    r.protocol("Protocol", "OTEL_EXPORTER_OTLP_LOGS_PROTOCOL", "OTEL_EXPORTER_OTLP_PROTOCOL"),
+		Insecure:    r.bool("Insecure", false, "OTEL_EXPORTER_OTLP_LOGS_INSECURE", "OTEL_EXPORTER_OTLP_INSECURE"),
+		Headers:     r.headers("Headers", "OTEL_EXPORTER_OTLP_LOGS_HEADERS", "OTEL_EXPORTER_OTLP_HEADERS"),
+		Compression: r.get("Compression", "OTEL_EXPORTER_OTLP_LOGS_COMPRESSION", "OTEL_EXPORTER_OTLP_COMPRESSION"),
+		LogDir:      os.Getenv("LOG_DIR"),
+
+		LogRetention: r.duration("LogRetention", 7*24*time.Hour, "LOG_RETENTION"),
+		LogMaxSize:   r.size("LogMaxSize", "LOG_MAX_SIZE", 1024*1024*1024),
+
+		Timeout:              r.duration("Timeout", 10*time.Second, "OTEL_EXPORTER_OTLP_LOGS_TIMEOUT", "OTEL_EXPORTER_OTLP_TIMEOUT"),
+		RetryEnabled:         r.bool("RetryEnabled", true, "RETRY_ENABLED"),
+		RetryInitialInterval: r.duration("RetryInitialInterval", 5*time.Second, "RETRY_INITIAL_INTERVAL"),
+		RetryMaxInterval:     r.duration("RetryMaxInterval", 30*time.Second, "RETRY_MAX_INTERVAL"),
+		RetryMaxElapsedTime:  r.duration("RetryMaxElapsedTime", time.Minute, "RETRY_MAX_ELAPSED_TIME"),
+
+		BatchMaxQueueSize:       r.int("BatchMaxQueueSize", 2048, "OTEL_BLRP_MAX_QUEUE_SIZE"),
+		BatchScheduleDelay:      r.duration("BatchScheduleDelay", time.Second, "OTEL_BLRP_SCHEDULE_DELAY"),
+		BatchMaxExportBatchSize: r.int("BatchMaxExportBatchSize", 512, "OTEL_BLRP_MAX_EXPORT_BATCH_SIZE"),
+		BatchExportTimeout:      r.duration("BatchExportTimeout", 30*time.Second, "OTEL_BLRP_EXPORT_TIMEOUT"),
+
+		MetricsExporter: strings.ToLower(getenvDefault("OTEL_METRICS_EXPORTER", "otlp")),
+		MetricsInterval: r.duration("MetricsInterval", time.Minute, "OTEL_METRIC_EXPORT_INTERVAL"),
+		MetricsAddr:     r.get("MetricsAddr", "METRICS_ADDR"),
+
+		Certificate:       r.get("Certificate", "OTEL_EXPORTER_OTLP_LOGS_CERTIFICATE", "OTEL_EXPORTER_OTLP_CERTIFICATE"),
+		ClientCertificate: r.get("ClientCertificate", "OTEL_EXPORTER_OTLP_LOGS_CLIENT_CERTIFICATE", "OTEL_EXPORTER_OTLP_CLIENT_CERTIFICATE"),
+		ClientKey:         r.get("ClientKey", "OTEL_EXPORTER_OTLP_LOGS_CLIENT_KEY", "OTEL_EXPORTER_OTLP_CLIENT_KEY"),
+
+		AttributeSchema: strings.ToLower(getenvDefault("ATTRIBUTE_SCHEMA", "legacy")),
+
+		QueueDir:         os.Getenv("QUEUE_DIR"),
+		QueueMaxSize:     r.size("QueueMaxSize", "QUEUE_MAX_SIZE", 256*1024*1024),
+		QueueSegmentSize: r.size("QueueSegmentSize", "QUEUE_SEGMENT_SIZE", 8*1024*1024),
+		QueueFsync:       strings.ToLower(getenvDefault("QUEUE_FSYNC", "interval")),
+
+		StopTimeout:     r.duration("StopTimeout", 5*time.Second, "STOP_TIMEOUT"),
+		ShutdownTimeout: r.duration("ShutdownTimeout", 8*time.Second, "SHUTDOWN_TIMEOUT"),
+
+		Degraded: r.bool("Degraded", false, "DEGRADED_MODE"),
+
+		LogLevel:  strings.ToLower(getenvDefault("LOG_LEVEL", "info")),
+		LogFormat: strings.ToLower(getenvDefault("LOG_FORMAT", "text")),
 	}
+	if c.Endpoint == "" {
+		c.Endpoint = "http://localhost:4317"
+	}
+	c.env, c.problems = r.names, r.problems
 	return c
 }
 
+// WithLogOpts returns the effective exporter config of a container, applying the
+// per-container log-opts endpoint, protocol, headers, insecure and compression on top
+// of the plugin-level config. Plugin-level headers are not sent to an overridden
+// endpoint unless the container sets its own headers.
+func (c Config) WithLogOpts(opts map[string]string) (Config, error) {
+	out := c
+	if v, ok := opts["endpoint"]; ok {
+		out.Endpoint = strings.TrimSpace(v)
+		if err := validateEndpoint(out.Endpoint); err != nil {
+			return c, err
+		}
+		if out.Endpoint != c.Endpoint {
+			out.Headers = map[string]string{}
+		}
+	}
+	if v, ok := opts["protocol"]; ok {
+		if out.Protocol = normalizeProtocol(v); out.Protocol == "" {
+			return c, fmt.Errorf("invalid protocol %q: want grpc or http/protobuf", v)
+		}
+	}
+	if v, ok := opts["headers"]; ok {
+		out.Headers = parseHeaders(v)
+	}
+	if v, ok := opts["insecure"]; ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return c, fmt.Errorf("invalid insecure %q", v)
+		}
+		out.Insecure = b
+	}
+	if v, ok := opts["compression"]; ok {
+		switch strings.ToLower(v) {
+		case "gzip":
+			out.Compression = "gzip"
+		case "", "none":
+			out.Compression = ""
+		default:
+			return c, fmt.Errorf("invalid compression %q: want gzip or none", v)
+		}
+	}
+	return out, nil
+}
+
 func normalizeProtocol(p string) string {
 	p = strings.TrimSpace(strings.ToLower(p))
 	switch p {
@@ -42,18 +189,27 @@ func normalizeProtocol(p string) string {
 }
 
 func parseHeaders(s string) map[string]string {
+	m, _ := parseHeadersStrict(s)
+	return m
+}
+
+// parseHeadersStrict is parseHeaders that also reports the malformed pairs it skipped.
+func parseHeadersStrict(s string) (map[string]string, []string) {
 	m := map[string]string{}
 	if s == "" {
-		return m
+		return m, nil
 	}
+	var bad []string
 	parts := strings.Split(s, ",")
 	for _, p := range parts {
 		kv := strings.SplitN(strings.TrimSpace(p), "=", 2)
-		if len(kv) == 2 {
+		if len(kv) == 2 && strings.TrimSpace(kv[0]) != "" {
 			m[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+		} else {
+			bad = append(bad, p)
 		}
 	}
-	return m
+	return m, bad
 }
 
 func getenvDefault(k, d string) string {
@@ -62,3 +218,103 @@ func getenvDefault(k, d string) string {
 	}
 	return d
 }
+
+// envReader reads settings from the environment, remembering which variable each
+// came from and which values could not be parsed, for Validate.
+type envReader struct {
+	names    map[string]string
+	problems []Problem
+}
+
+// get returns the first non-empty variable of keys.
+func (r *envReader) get(setting string, keys ...string) string {
+	for _, k := range keys {
+		if v := os.Getenv(k); v != "" {
+			r.names[setting] = k
+			return v
+		}
+	}
+	r.names[setting] = keys[0]
+	return ""
+}
+
+func (r *envReader) fail(setting, format string, args ...any) {
+	r.problems = append(r.problems, Problem{Env: r.names[setting], Message: fmt.Sprintf(format, args...)})
+}
+
+func (r *envReader) protocol(setting string, keys ...string) string {
+	v := r.get(setting, keys...)
+	p := normalizeProtocol(v)
+	if v != "" && p == "" {
+		r.fail(setting, "unsupported protocol %q: want grpc or http/protobuf", v)
+	}
+	return p
+}
+
+func (r *envReader) bool(setting string, d bool, keys ...string) bool {
+	v := r.get(setting, keys...)
+	if v == "" {
+		return d
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		r.fail(setting, "invalid boolean %q", v)
+		return d
+	}
+	return b
+}
+
+// duration reads a positive duration, given in milliseconds like the OTEL_* variables
+// or as a Go duration such as "5s".
+func (r *envReader) duration(setting string, d time.Duration, keys ...string) time.Duration {
+	v := r.get(setting, keys...)
+	if v == "" {
+		return d
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	dur := time.Duration(n) * time.Millisecond
+	if err != nil {
+		dur, err = time.ParseDuration(v)
+	}
+	if err != nil || dur <= 0 {
+		r.fail(setting, "invalid duration %q: want positive milliseconds or a duration such as 5s", v)
+		return d
+	}
+	return dur
+}
+
+// int reads a positive integer.
+func (r *envReader) int(setting string, d int, keys ...string) int {
+	v := r.get(setting, keys...)
+	if v == "" {
+		return d
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		r.fail(setting, "invalid value %q: want a positive integer", v)
+		return d
+	}
+	return n
+}
+
+func (r *envReader) headers(setting string, keys ...string) map[string]string {
+	m, bad := parseHeadersStrict(r.get(setting, keys...))
+	for _, p := range bad {
+		r.fail(setting, "malformed header %q: want key=value", p)
+	}
+	return m
+}
+
+// size reads a size such as "256m" or "1g", falling back to d when unset or invalid.
+func (r *envReader) size(setting, key string, d int64) int64 {
+	v := r.get(setting, key)
+	if v == "" {
+		return d
+	}
+	n, err := units.RAMInBytes(v)
+	if err != nil || n <= 0 {
+		r.fail(setting, "invalid size %q", v)
+		return d
+	}
+	return n
+}
@@ -2,7 +2,9 @@ package config
 
 import (
 	"os"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestNormalizeProtocol(t *testing.T) {
@@ -53,6 +55,11 @@ func TestFromEnv(t *testing.T) {
 		"OTEL_EXPORTER_OTLP_LOGS_HEADERS",
 		"OTEL_EXPORTER_OTLP_HEADERS",
 		"OTEL_EXPORTER_OTLP_LOGS_COMPRESSION",
+		"OTEL_EXPORTER_OTLP_COMPRESSION",
+		"OTEL_EXPORTER_OTLP_LOGS_CERTIFICATE",
+		"OTEL_EXPORTER_OTLP_CERTIFICATE",
+		"OTEL_EXPORTER_OTLP_LOGS_CLIENT_CERTIFICATE",
+		"OTEL_EXPORTER_OTLP_CLIENT_KEY",
 	} {
 		_, r := save(k)
 		restores = append(restores, r)
@@ -102,6 +109,9 @@ func TestFromEnv(t *testing.T) {
 	_ = os.Setenv("OTEL_EXPORTER_OTLP_INSECURE", "TRUE")
 	_ = os.Unsetenv("OTEL_EXPORTER_OTLP_LOGS_HEADERS")
 	_ = os.Setenv("OTEL_EXPORTER_OTLP_HEADERS", "a=b")
+	_ = os.Setenv("OTEL_EXPORTER_OTLP_CERTIFICATE", "/certs/ca.pem")
+	_ = os.Setenv("OTEL_EXPORTER_OTLP_LOGS_CLIENT_CERTIFICATE", "/certs/client.pem")
+	_ = os.Setenv("OTEL_EXPORTER_OTLP_CLIENT_KEY", "/certs/client-key.pem")
 	cfg = FromEnv()
 	if cfg.Endpoint != "collector:4317" {
 		t.Fatalf("endpoint=%q", cfg.Endpoint)
@@ -115,4 +125,92 @@ func TestFromEnv(t *testing.T) {
 	if cfg.Headers["a"] != "b" {
 		t.Fatalf("headers=%v", cfg.Headers)
 	}
+	if cfg.Certificate != "/certs/ca.pem" || cfg.ClientCertificate != "/certs/client.pem" || cfg.ClientKey != "/certs/client-key.pem" {
+		t.Fatalf("tls files=%q %q %q", cfg.Certificate, cfg.ClientCertificate, cfg.ClientKey)
+	}
+}
+
+func TestWithLogOpts(t *testing.T) {
+	base := Config{Endpoint: "http://localhost:4317", Protocol: "grpc", Headers: map[string]string{"authorization": "plugin"}}
+
+	cfg, err := base.WithLogOpts(map[string]string{"include-labels": "true"})
+	if err != nil || cfg.Endpoint != base.Endpoint || cfg.Headers["authorization"] != "plugin" {
+		t.Fatalf("no overrides: cfg=%+v err=%v", cfg, err)
+	}
+
+	// A different endpoint does not inherit the plugin-level headers.
+	cfg, err = base.WithLogOpts(map[string]string{"endpoint": "https://tenant-a:4318", "protocol": "http/protobuf", "insecure": "true", "compression": "gzip"})
+	if err != nil {
+		t.Fatalf("err=%v", err)
+	}
+	if cfg.Endpoint != "https://tenant-a:4318" || cfg.Protocol != "http" || !cfg.Insecure || cfg.Compression != "gzip" || len(cfg.Headers) != 0 {
+		t.Fatalf("cfg=%+v", cfg)
+	}
+	if base.Headers["authorization"] != "plugin" {
+		t.Fatalf("base config modified: %+v", base)
+	}
+
+	cfg, err = base.WithLogOpts(map[string]string{"endpoint": "tenant-b:4317", "headers": "authorization=tenant-b"})
+	if err != nil || cfg.Headers["authorization"] != "tenant-b" {
+		t.Fatalf("headers: cfg=%+v err=%v", cfg, err)
+	}
+
+	for _, opts := range []map[string]string{
+		{"endpoint": " "},
+		{"endpoint": "ftp://collector:4317"},
+		{"endpoint": "collector"},
+		{"endpoint": "collector:99999"},
+		{"protocol": "udp"},
+		{"insecure": "maybe"},
+		{"compression": "zstd"},
+	} {
+		if _, err := base.WithLogOpts(opts); err == nil {
+			t.Fatalf("%v accepted", opts)
+		}
+	}
+}
+
+func TestFromEnv_ExportTuning(t *testing.T) {
+	for k, v := range map[string]string{
+		"OTEL_EXPORTER_OTLP_LOGS_TIMEOUT": "2500",
+		"OTEL_EXPORTER_OTLP_TIMEOUT":      "",
+		"RETRY_ENABLED":                   "false",
+		"RETRY_INITIAL_INTERVAL":          "",
+		"RETRY_MAX_INTERVAL":              "2m",
+		"RETRY_MAX_ELAPSED_TIME":          "-1",
+		"OTEL_BLRP_MAX_QUEUE_SIZE":        "8192",
+		"OTEL_BLRP_SCHEDULE_DELAY":        "200",
+		"OTEL_BLRP_MAX_EXPORT_BATCH_SIZE": "many",
+		"OTEL_BLRP_EXPORT_TIMEOUT":        "",
+		"STOP_TIMEOUT":                    "2s",
+		"SHUTDOWN_TIMEOUT":                "20000",
+		"LOG_RETENTION":                   "24h",
+		"LOG_MAX_SIZE":                    "",
+	} {
+		t.Setenv(k, v)
+	}
+	cfg := FromEnv()
+	if cfg.Timeout != 2500*time.Millisecond || cfg.RetryEnabled || cfg.RetryInitialInterval != 5*time.Second || cfg.RetryMaxInterval != 2*time.Minute {
+		t.Fatalf("exporter: %+v", cfg)
+	}
+	if cfg.BatchMaxQueueSize != 8192 || cfg.BatchScheduleDelay != 200*time.Millisecond || cfg.BatchExportTimeout != 30*time.Second {
+		t.Fatalf("batch: %+v", cfg)
+	}
+	if cfg.StopTimeout != 2*time.Second || cfg.ShutdownTimeout != 20*time.Second {
+		t.Fatalf("stop timeouts: %s %s", cfg.StopTimeout, cfg.ShutdownTimeout)
+	}
+	if cfg.LogRetention != 24*time.Hour || cfg.LogMaxSize != 1<<30 {
+		t.Fatalf("log store: %s %d", cfg.LogRetention, cfg.LogMaxSize)
+	}
+	// Unparsable values keep the default and are reported by Validate.
+	if cfg.RetryMaxElapsedTime != time.Minute || cfg.BatchMaxExportBatchSize != 512 {
+		t.Fatalf("defaults: %+v", cfg)
+	}
+	var envs []string
+	for _, p := range cfg.Validate() {
+		envs = append(envs, p.Env)
+	}
+	if strings.Join(envs, ",") != "RETRY_MAX_ELAPSED_TIME,OTEL_BLRP_MAX_EXPORT_BATCH_SIZE" {
+		t.Fatalf("problems=%v", envs)
+	}
 }
@@ -0,0 +1,230 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Problem is an invalid setting, named after the environment variable it came from.
+type Problem struct {
+	Env     string `json:"env"`
+	Message string `json:"message"`
+}
+
+func (p Problem) String() string {
+	return p.Env + ": " + p.Message
+}
+
+// Upper bounds guarding against typos such as a duration in microseconds.
+const (
+	maxDuration       = time.Hour
+	maxBatchQueueSize = 1 << 20
+)
+
+// defaultEnv names the variable of each setting when the config was not read by FromEnv.
+var defaultEnv = map[string]string{
+	"Endpoint":          "OTEL_EXPORTER_OTLP_LOGS_ENDPOINT",
+	"Protocol":          "OTEL_EXPORTER_OTLP_LOGS_PROTOCOL",
+	"Insecure":          "OTEL_EXPORTER_OTLP_LOGS_INSECURE",
+	"Headers":           "OTEL_EXPORTER_OTLP_LOGS_HEADERS",
+	"Compression":       "OTEL_EXPORTER_OTLP_LOGS_COMPRESSION",
+	"Certificate":       "OTEL_EXPORTER_OTLP_LOGS_CERTIFICATE",
+	"ClientCertificate": "OTEL_EXPORTER_OTLP_LOGS_CLIENT_CERTIFICATE",
+	"ClientKey":         "OTEL_EXPORTER_OTLP_LOGS_CLIENT_KEY",
+	"AttributeSchema":   "ATTRIBUTE_SCHEMA",
+	"LogMaxSize":        "LOG_MAX_SIZE",
+	"QueueMaxSize":      "QUEUE_MAX_SIZE",
+	"QueueSegmentSize":  "QUEUE_SEGMENT_SIZE",
+	"QueueFsync":        "QUEUE_FSYNC",
+	"Degraded":          "DEGRADED_MODE",
+	"LogLevel":          "LOG_LEVEL",
+	"LogFormat":         "LOG_FORMAT",
+
+	"Timeout":                 "OTEL_EXPORTER_OTLP_LOGS_TIMEOUT",
+	"RetryEnabled":            "RETRY_ENABLED",
+	"RetryInitialInterval":    "RETRY_INITIAL_INTERVAL",
+	"RetryMaxInterval":        "RETRY_MAX_INTERVAL",
+	"RetryMaxElapsedTime":     "RETRY_MAX_ELAPSED_TIME",
+	"BatchMaxQueueSize":       "OTEL_BLRP_MAX_QUEUE_SIZE",
+	"BatchScheduleDelay":      "OTEL_BLRP_SCHEDULE_DELAY",
+	"BatchMaxExportBatchSize": "OTEL_BLRP_MAX_EXPORT_BATCH_SIZE",
+	"BatchExportTimeout":      "OTEL_BLRP_EXPORT_TIMEOUT",
+	"MetricsExporter":         "OTEL_METRICS_EXPORTER",
+	"MetricsInterval":         "OTEL_METRIC_EXPORT_INTERVAL",
+	"MetricsAddr":             "METRICS_ADDR",
+	"LogRetention":            "LOG_RETENTION",
+	"StopTimeout":             "STOP_TIMEOUT",
+	"ShutdownTimeout":         "SHUTDOWN_TIMEOUT",
+}
+
+func (c Config) envName(setting string) string {
+	if n, ok := c.env[setting]; ok {
+		return n
+	}
+	return defaultEnv[setting]
+}
+
+// Validate checks the whole config and returns every problem found, including the
+// values FromEnv could not parse. An empty result means the config is usable.
+func (c Config) Validate() []Problem {
+	problems := append([]Problem(nil), c.problems...)
+	fail := func(setting, format string, args ...any) {
+		problems = append(problems, Problem{Env: c.envName(setting), Message: fmt.Sprintf(format, args...)})
+	}
+
+	if err := validateEndpoint(c.Endpoint); err != nil {
+		fail("Endpoint", "%v", err)
+	}
+	switch c.Protocol {
+	case "", "grpc", "http":
+	default:
+		fail("Protocol", "unsupported protocol %q: want grpc or http/protobuf", c.Protocol)
+	}
+	for k := range c.Headers {
+		if strings.TrimSpace(k) == "" {
+			fail("Headers", "header with empty name")
+		}
+	}
+	switch strings.ToLower(c.Compression) {
+	case "", "gzip", "none":
+	default:
+		fail("Compression", "unsupported compression %q: want gzip or none", c.Compression)
+	}
+
+	if c.Certificate != "" {
+		if err := validateCA(c.Certificate); err != nil {
+			fail("Certificate", "%v", err)
+		}
+	}
+	switch {
+	case c.ClientCertificate != "" && c.ClientKey == "":
+		fail("ClientKey", "not set, but %s is", c.envName("ClientCertificate"))
+	case c.ClientCertificate == "" && c.ClientKey != "":
+		fail("ClientCertificate", "not set, but %s is", c.envName("ClientKey"))
+	case c.ClientCertificate != "":
+		if _, err := tls.LoadX509KeyPair(c.ClientCertificate, c.ClientKey); err != nil {
+			fail("ClientCertificate", "cannot load key pair with %s: %v", c.envName("ClientKey"), err)
+		}
+	}
+
+	for _, d := range []struct {
+		setting string
+		value   time.Duration
+	}{
+		{"Timeout", c.Timeout},
+		{"RetryInitialInterval", c.RetryInitialInterval},
+		{"RetryMaxInterval", c.RetryMaxInterval},
+		{"RetryMaxElapsedTime", c.RetryMaxElapsedTime},
+		{"BatchScheduleDelay", c.BatchScheduleDelay},
+		{"BatchExportTimeout", c.BatchExportTimeout},
+		{"MetricsInterval", c.MetricsInterval},
+		{"StopTimeout", c.StopTimeout},
+		{"ShutdownTimeout", c.ShutdownTimeout},
+	} {
+		if d.value < 0 || d.value > maxDuration {
+			fail(d.setting, "duration %s out of range (0, %s]", d.value, maxDuration)
+		}
+	}
+	if c.RetryInitialInterval > 0 && c.RetryMaxInterval > 0 && c.RetryInitialInterval > c.RetryMaxInterval {
+		fail("RetryInitialInterval", "%s exceeds %s %s", c.RetryInitialInterval, c.envName("RetryMaxInterval"), c.RetryMaxInterval)
+	}
+	if c.BatchMaxQueueSize < 0 || c.BatchMaxQueueSize > maxBatchQueueSize {
+		fail("BatchMaxQueueSize", "queue size %d out of range [1, %d]", c.BatchMaxQueueSize, maxBatchQueueSize)
+	}
+	if c.BatchMaxExportBatchSize < 0 || (c.BatchMaxQueueSize > 0 && c.BatchMaxExportBatchSize > c.BatchMaxQueueSize) {
+		fail("BatchMaxExportBatchSize", "batch size %d out of range [1, %s %d]", c.BatchMaxExportBatchSize, c.envName("BatchMaxQueueSize"), c.BatchMaxQueueSize)
+	}
+
+	switch c.MetricsExporter {
+	case "", "otlp", "none":
+	default:
+		fail("MetricsExporter", "unsupported exporter %q: want otlp or none", c.MetricsExporter)
+	}
+	if c.MetricsAddr != "" {
+		if _, port, err := net.SplitHostPort(c.MetricsAddr); err != nil {
+			fail("MetricsAddr", "invalid listen address %q: want [host]:port", c.MetricsAddr)
+		} else if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+			fail("MetricsAddr", "invalid listen address %q: bad port %q", c.MetricsAddr, port)
+		}
+	}
+
+	switch c.AttributeSchema {
+	case "", "legacy", "semconv", "both":
+	default:
+		fail("AttributeSchema", "unsupported schema %q: want legacy, semconv or both", c.AttributeSchema)
+	}
+	switch c.LogLevel {
+	case "", "debug", "info", "warn", "error":
+	default:
+		fail("LogLevel", "unsupported level %q: want debug, info, warn or error", c.LogLevel)
+	}
+	switch c.LogFormat {
+	case "", "text", "json":
+	default:
+		fail("LogFormat", "unsupported format %q: want text or json", c.LogFormat)
+	}
+	if c.QueueDir != "" {
+		if c.QueueSegmentSize > c.QueueMaxSize {
+			fail("QueueSegmentSize", "segment size %d exceeds %s %d", c.QueueSegmentSize, c.envName("QueueMaxSize"), c.QueueMaxSize)
+		}
+		switch c.QueueFsync {
+		case "always", "interval", "never":
+		default:
+			fail("QueueFsync", "unsupported policy %q: want always, interval or never", c.QueueFsync)
+		}
+	}
+	return problems
+}
+
+// validateEndpoint accepts an http(s) URL or a host:port pair.
+func validateEndpoint(endpoint string) error {
+	if endpoint == "" {
+		return fmt.Errorf("empty endpoint")
+	}
+	if strings.Contains(endpoint, "://") {
+		u, err := url.Parse(endpoint)
+		if err != nil {
+			return fmt.Errorf("invalid endpoint URL %q: %v", endpoint, err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return fmt.Errorf("invalid endpoint %q: scheme must be http or https", endpoint)
+		}
+		if u.Hostname() == "" {
+			return fmt.Errorf("invalid endpoint %q: missing host", endpoint)
+		}
+		if p := u.Port(); p != "" {
+			return validatePort(endpoint, p)
+		}
+		return nil
+	}
+	host, port, err := net.SplitHostPort(endpoint)
+	if err != nil || host == "" {
+		return fmt.Errorf("invalid endpoint %q: want http(s)://host[:port] or host:port", endpoint)
+	}
+	return validatePort(endpoint, port)
+}
+
+func validatePort(endpoint, port string) error {
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("invalid endpoint %q: bad port %q", endpoint, port)
+	}
+	return nil
+}
+
+func validateCA(file string) error {
+	pem, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("cannot read CA certificate: %v", err)
+	}
+	if !x509.NewCertPool().AppendCertsFromPEM(pem) {
+		return fmt.Errorf("no PEM certificate found in %s", file)
+	}
+	return nil
+}
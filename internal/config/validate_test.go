@@ -0,0 +1,115 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func problemEnvs(problems []Problem) []string {
+	var out []string
+	for _, p := range problems {
+		out = append(out, p.Env)
+	}
+	return out
+}
+
+func TestValidate(t *testing.T) {
+	dir := t.TempDir()
+	notPEM := filepath.Join(dir, "ca.pem")
+	_ = os.WriteFile(notPEM, []byte("not a certificate"), 0o600)
+
+	if p := (Config{Endpoint: "collector:4317"}).Validate(); len(p) != 0 {
+		t.Fatalf("valid config: %v", p)
+	}
+
+	cfg := Config{
+		Endpoint:          "ftp://collector",
+		Protocol:          "udp",
+		Compression:       "zstd",
+		Certificate:       notPEM,
+		ClientCertificate: filepath.Join(dir, "client.pem"),
+		MetricsAddr:       "9464",
+		AttributeSchema:   "ecs",
+		QueueDir:          dir,
+		QueueMaxSize:      1,
+		QueueSegmentSize:  2,
+		QueueFsync:        "sometimes",
+		LogLevel:          "verbose",
+	}
+	got := problemEnvs(cfg.Validate())
+	want := []string{
+		"OTEL_EXPORTER_OTLP_LOGS_ENDPOINT",
+		"OTEL_EXPORTER_OTLP_LOGS_PROTOCOL",
+		"OTEL_EXPORTER_OTLP_LOGS_COMPRESSION",
+		"OTEL_EXPORTER_OTLP_LOGS_CERTIFICATE",
+		"OTEL_EXPORTER_OTLP_LOGS_CLIENT_KEY",
+		"METRICS_ADDR",
+		"ATTRIBUTE_SCHEMA",
+		"LOG_LEVEL",
+		"QUEUE_SEGMENT_SIZE",
+		"QUEUE_FSYNC",
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("problems=%v\nwant %v", got, want)
+	}
+
+	ranges := Config{
+		Endpoint:                "collector:4317",
+		Timeout:                 2 * time.Hour,
+		RetryInitialInterval:    time.Minute,
+		RetryMaxInterval:        time.Second,
+		BatchMaxQueueSize:       100,
+		BatchMaxExportBatchSize: 200,
+	}
+	got = problemEnvs(ranges.Validate())
+	want = []string{"OTEL_EXPORTER_OTLP_LOGS_TIMEOUT", "RETRY_INITIAL_INTERVAL", "OTEL_BLRP_MAX_EXPORT_BATCH_SIZE"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("range problems=%v\nwant %v", got, want)
+	}
+
+	for _, endpoint := range []string{"", "collector", "collector:port", "http://:4317", "https://collector:99999"} {
+		if p := (Config{Endpoint: endpoint}).Validate(); len(p) != 1 {
+			t.Fatalf("endpoint %q: problems=%v", endpoint, p)
+		}
+	}
+}
+
+func TestValidate_FromEnv(t *testing.T) {
+	for k, v := range map[string]string{
+		"OTEL_EXPORTER_OTLP_LOGS_ENDPOINT":      "",
+		"OTEL_EXPORTER_OTLP_ENDPOINT":           "collector:4317",
+		"OTEL_EXPORTER_OTLP_LOGS_HEADERS":       "",
+		"OTEL_EXPORTER_OTLP_HEADERS":            "a=b,broken",
+		"OTEL_EXPORTER_OTLP_LOGS_INSECURE":      "maybe",
+		"OTEL_EXPORTER_OTLP_LOGS_CERTIFICATE":   "",
+		"OTEL_EXPORTER_OTLP_CERTIFICATE":        "/does/not/exist.pem",
+		"QUEUE_MAX_SIZE":                        "lots",
+		"OTEL_EXPORTER_OTLP_LOGS_PROTOCOL":      "",
+		"OTEL_EXPORTER_OTLP_PROTOCOL":           "",
+		"OTEL_EXPORTER_OTLP_LOGS_COMPRESSION":   "",
+		"OTEL_EXPORTER_OTLP_COMPRESSION":        "",
+		"OTEL_EXPORTER_OTLP_LOGS_CLIENT_KEY":    "",
+		"OTEL_EXPORTER_OTLP_CLIENT_KEY":         "",
+		"OTEL_EXPORTER_OTLP_CLIENT_CERTIFICATE": "",
+	} {
+		t.Setenv(k, v)
+	}
+	cfg := FromEnv()
+	got := problemEnvs(cfg.Validate())
+	// Problems name the variable a value was actually read from.
+	want := []string{
+		"OTEL_EXPORTER_OTLP_LOGS_INSECURE",
+		"OTEL_EXPORTER_OTLP_HEADERS",
+		"QUEUE_MAX_SIZE",
+		"OTEL_EXPORTER_OTLP_CERTIFICATE",
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("problems=%v\nwant %v", got, want)
+	}
+	if cfg.Headers["a"] != "b" {
+		t.Fatalf("headers=%v", cfg.Headers)
+	}
+}
@@ -0,0 +1,567 @@
+// Package diskqueue implements a segmented, append-only on-disk FIFO of opaque records
+// with a persisted read cursor, used to keep log batches across collector outages and
+// plugin restarts.
+package diskqueue
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Fsync policies.
+const (
+	FsyncAlways   = "always"   // fsync after every append
+	FsyncInterval = "interval" // fsync at most once per second while appending
+	FsyncNever    = "never"    // leave it to the OS
+)
+
+const (
+	segmentExt    = ".seg"
+	cursorFile    = "cursor"
+	frameHeader   = 8 // uint32 length + uint32 CRC32 (IEEE) of the payload
+	fsyncInterval = time.Second
+)
+
+var ErrClosed = errors.New("diskqueue: closed")
+
+type Options struct {
+	Dir string
+	// MaxBytes bounds the total size of all segments; the oldest segments are evicted,
+	// read or not, to make room for new records.
+	MaxBytes int64
+	// SegmentBytes is the size at which the write segment is rotated.
+	SegmentBytes int64
+	Fsync        string
+	// OnEvict, if set, is called with the unacknowledged records of every segment
+	// evicted to respect MaxBytes, before the segment is removed. It is called with the
+	// queue locked and must not use the queue.
+	OnEvict func(records [][]byte)
+	// Budget, if set, bounds the total size of this queue and the others sharing it,
+	// on top of MaxBytes.
+	Budget *Budget
+}
+
+// Budget bounds the total size of several queues, such as those of different
+// exporters below one directory. When an append would exceed it, the oldest segment
+// among all of them is evicted, read or not.
+type Budget struct {
+	maxBytes int64
+	// Serializes the appends of the sharing queues; taken before Queue.mu
+	mu     sync.Mutex
+	used   atomic.Int64
+	queues map[*Queue]struct{}
+}
+
+// NewBudget returns a budget of maxBytes to share between queues.
+func NewBudget(maxBytes int64) *Budget {
+	return &Budget{maxBytes: maxBytes, queues: map[*Queue]struct{}{}}
+}
+
+// Used returns the bytes held by the open queues sharing the budget.
+func (b *Budget) Used() int64 {
+	return b.used.Load()
+}
+
+// reserve evicts the oldest segments of the sharing queues until n more bytes fit.
+// Write segments are never evicted.
+func (b *Budget) reserve(n int64) error {
+	for b.used.Load()+n > b.maxBytes {
+		var oldest *Queue
+		var at time.Time
+		for q := range b.queues {
+			if t, ok := q.oldestSegment(); ok && (oldest == nil || t.Before(at)) {
+				oldest, at = q, t
+			}
+		}
+		if oldest == nil {
+			return nil
+		}
+		if err := oldest.evictForBudget(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Position identifies a record boundary in the queue.
+type Position struct {
+	seg uint64
+	off int64
+}
+
+// Queue is safe for concurrent use by one writer and one reader.
+type Queue struct {
+	mu       sync.Mutex
+	opts     Options
+	segments []segment // oldest first; the last one is being written
+	w        *os.File
+	r        *os.File // reader of the head segment
+	rseg     uint64
+	head     Position
+	size     int64
+	evicted  int64
+	lastSync time.Time
+	notify   chan struct{}
+	closed   bool
+}
+
+type segment struct {
+	id   uint64
+	size int64
+	// When the segment was created, or last written for segments of an earlier run
+	created time.Time
+}
+
+// Open opens or creates the queue in opts.Dir. Records left by a previous run are
+// kept and read from the persisted cursor; appends always go to a fresh segment so a
+// torn write at the end of an old segment cannot corrupt new records.
+func Open(opts Options) (*Queue, error) {
+	if opts.SegmentBytes <= 0 || opts.MaxBytes < opts.SegmentBytes {
+		return nil, fmt.Errorf("diskqueue: max size %d must be at least the segment size %d", opts.MaxBytes, opts.SegmentBytes)
+	}
+	if err := os.MkdirAll(opts.Dir, 0o700); err != nil {
+		return nil, fmt.Errorf("diskqueue: %w", err)
+	}
+	q := &Queue{opts: opts, notify: make(chan struct{})}
+
+	entries, err := os.ReadDir(opts.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("diskqueue: %w", err)
+	}
+	for _, e := range entries {
+		id, err := strconv.ParseUint(strings.TrimSuffix(e.Name(), segmentExt), 10, 64)
+		if err != nil || !strings.HasSuffix(e.Name(), segmentExt) {
+			continue
+		}
+		st, err := e.Info()
+		if err != nil {
+			return nil, fmt.Errorf("diskqueue: %w", err)
+		}
+		q.segments = append(q.segments, segment{id: id, size: st.Size(), created: st.ModTime()})
+	}
+	sort.Slice(q.segments, func(i, j int) bool { return q.segments[i].id < q.segments[j].id })
+
+	q.head = q.readCursor()
+	// Drop segments the cursor has already moved past.
+	for len(q.segments) > 0 && q.segments[0].id < q.head.seg {
+		if err := q.removeOldest(); err != nil {
+			return nil, err
+		}
+	}
+	for _, s := range q.segments {
+		q.size += s.size
+	}
+
+	var next uint64 = 1
+	if n := len(q.segments); n > 0 {
+		next = q.segments[n-1].id + 1
+	}
+	if err := q.openSegment(next); err != nil {
+		return nil, err
+	}
+	// Start over from the oldest segment if the cursor is missing or refers to a
+	// segment that no longer exists.
+	found := false
+	for _, seg := range q.segments {
+		found = found || seg.id == q.head.seg
+	}
+	if !found {
+		q.head = Position{seg: q.segments[0].id}
+	}
+	if b := opts.Budget; b != nil {
+		b.mu.Lock()
+		b.queues[q] = struct{}{}
+		b.used.Add(q.size)
+		b.mu.Unlock()
+	}
+	return q, nil
+}
+
+func (q *Queue) segmentPath(id uint64) string {
+	return filepath.Join(q.opts.Dir, fmt.Sprintf("%020d%s", id, segmentExt))
+}
+
+func (q *Queue) openSegment(id uint64) error {
+	f, err := os.OpenFile(q.segmentPath(id), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("diskqueue: %w", err)
+	}
+	q.w = f
+	q.segments = append(q.segments, segment{id: id, created: time.Now()})
+	return nil
+}
+
+// Append adds a record at the tail, evicting the oldest segments if the queue, or the
+// queues sharing its budget, are full.
+func (q *Queue) Append(data []byte) error {
+	n := int64(frameHeader + len(data))
+	if b := q.opts.Budget; b != nil {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if err := b.reserve(n); err != nil {
+			return err
+		}
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return ErrClosed
+	}
+	cur := &q.segments[len(q.segments)-1]
+	if cur.size > 0 && cur.size+n > q.opts.SegmentBytes {
+		if err := q.syncWriter(true); err != nil {
+			return err
+		}
+		if err := q.w.Close(); err != nil {
+			return fmt.Errorf("diskqueue: %w", err)
+		}
+		if err := q.openSegment(cur.id + 1); err != nil {
+			return err
+		}
+	}
+	for q.size+n > q.opts.MaxBytes && len(q.segments) > 1 {
+		if err := q.evictOldest(); err != nil {
+			return err
+		}
+	}
+
+	buf := make([]byte, n)
+	binary.BigEndian.PutUint32(buf[0:4], uint32(len(data)))
+	binary.BigEndian.PutUint32(buf[4:8], crc32.ChecksumIEEE(data))
+	copy(buf[frameHeader:], data)
+	if _, err := q.w.Write(buf); err != nil {
+		return fmt.Errorf("diskqueue: %w", err)
+	}
+	q.segments[len(q.segments)-1].size += n
+	q.grow(n)
+	if err := q.syncWriter(q.opts.Fsync == FsyncAlways); err != nil {
+		return err
+	}
+
+	close(q.notify)
+	q.notify = make(chan struct{})
+	return nil
+}
+
+func (q *Queue) syncWriter(force bool) error {
+	switch {
+	case q.opts.Fsync == FsyncNever:
+		return nil
+	case force || time.Since(q.lastSync) >= fsyncInterval:
+		q.lastSync = time.Now()
+		if err := q.w.Sync(); err != nil {
+			return fmt.Errorf("diskqueue: %w", err)
+		}
+	}
+	return nil
+}
+
+// evictOldest drops the oldest segment, which must not be the write segment, whether
+// it has been read or not.
+func (q *Queue) evictOldest() error {
+	seg := q.segments[0]
+	if q.opts.OnEvict != nil {
+		from := int64(0)
+		if seg.id == q.head.seg {
+			from = q.head.off
+		}
+		if records := q.unread(seg, from); len(records) > 0 {
+			q.opts.OnEvict(records)
+		}
+	}
+	if q.head.seg == seg.id {
+		q.head = Position{seg: q.segments[1].id}
+	}
+	q.evicted += seg.size
+	q.grow(-seg.size)
+	return q.removeOldest()
+}
+
+// oldestSegment returns when the oldest segment was created, unless the queue holds
+// only its write segment.
+func (q *Queue) oldestSegment() (time.Time, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed || len(q.segments) < 2 {
+		return time.Time{}, false
+	}
+	return q.segments[0].created, true
+}
+
+// evictForBudget evicts the oldest segment to make room for an append to another
+// queue sharing the budget.
+func (q *Queue) evictForBudget() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed || len(q.segments) < 2 {
+		return nil
+	}
+	return q.evictOldest()
+}
+
+// grow adds delta to the size of the queue and of its budget.
+func (q *Queue) grow(delta int64) {
+	q.size += delta
+	if q.opts.Budget != nil {
+		q.opts.Budget.used.Add(delta)
+	}
+}
+
+// unread returns the records of a finished segment from offset on, up to a torn or
+// corrupt tail.
+func (q *Queue) unread(seg segment, off int64) [][]byte {
+	f, err := os.Open(q.segmentPath(seg.id))
+	if err != nil {
+		return nil
+	}
+	defer func() { _ = f.Close() }()
+	var records [][]byte
+	for off < seg.size {
+		data, err := readFrame(f, off, seg.size)
+		if err != nil {
+			break
+		}
+		records = append(records, data)
+		off += frameHeader + int64(len(data))
+	}
+	return records
+}
+
+// readFrame reads and checks the record at off of a segment of the given size.
+func readFrame(r io.ReaderAt, off, segSize int64) ([]byte, error) {
+	var hdr [frameHeader]byte
+	if _, err := r.ReadAt(hdr[:], off); err != nil {
+		return nil, err
+	}
+	size := int64(binary.BigEndian.Uint32(hdr[0:4]))
+	if off+frameHeader+size > segSize {
+		return nil, io.ErrUnexpectedEOF
+	}
+	data := make([]byte, size)
+	if _, err := r.ReadAt(data, off+frameHeader); err != nil {
+		return nil, err
+	}
+	if crc32.ChecksumIEEE(data) != binary.BigEndian.Uint32(hdr[4:8]) {
+		return nil, errors.New("checksum mismatch")
+	}
+	return data, nil
+}
+
+// removeOldest deletes the oldest segment file.
+func (q *Queue) removeOldest() error {
+	id := q.segments[0].id
+	if q.r != nil && q.rseg == id {
+		_ = q.r.Close()
+		q.r = nil
+	}
+	q.segments = q.segments[1:]
+	if err := os.Remove(q.segmentPath(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("diskqueue: %w", err)
+	}
+	return nil
+}
+
+// Peek returns the oldest unacknowledged record and the position following it, which
+// is passed to Ack once the record has been handled. It blocks until a record is
+// available, ctx is done or the queue is closed.
+func (q *Queue) Peek(ctx context.Context) ([]byte, Position, error) {
+	for {
+		q.mu.Lock()
+		if q.closed {
+			q.mu.Unlock()
+			return nil, Position{}, ErrClosed
+		}
+		data, next, ok, err := q.read()
+		notify := q.notify
+		q.mu.Unlock()
+		if err != nil || ok {
+			return data, next, err
+		}
+		select {
+		case <-notify:
+		case <-ctx.Done():
+			return nil, Position{}, ctx.Err()
+		}
+	}
+}
+
+// read decodes the record at the head. Torn or corrupt tails of finished segments are
+// skipped by moving on to the next segment.
+func (q *Queue) read() ([]byte, Position, bool, error) {
+	for {
+		writeSeg := q.segments[len(q.segments)-1]
+		segSize := writeSeg.size
+		if q.head.seg != writeSeg.id {
+			segSize = -1
+			for _, s := range q.segments {
+				if s.id == q.head.seg {
+					segSize = s.size
+				}
+			}
+		}
+		if segSize < 0 || q.head.off >= segSize {
+			if q.head.seg == writeSeg.id {
+				return nil, Position{}, false, nil
+			}
+			if err := q.advanceSegment(); err != nil {
+				return nil, Position{}, false, err
+			}
+			continue
+		}
+
+		if q.r == nil || q.rseg != q.head.seg {
+			if q.r != nil {
+				_ = q.r.Close()
+			}
+			r, err := os.Open(q.segmentPath(q.head.seg))
+			if err != nil {
+				return nil, Position{}, false, fmt.Errorf("diskqueue: %w", err)
+			}
+			q.r, q.rseg = r, q.head.seg
+		}
+		data, err := readFrame(q.r, q.head.off, segSize)
+		if err != nil {
+			if q.head.seg == writeSeg.id {
+				return nil, Position{}, false, fmt.Errorf("diskqueue: corrupt write segment: %w", err)
+			}
+			if err := q.advanceSegment(); err != nil {
+				return nil, Position{}, false, err
+			}
+			continue
+		}
+		return data, Position{seg: q.head.seg, off: q.head.off + frameHeader + int64(len(data))}, true, nil
+	}
+}
+
+// advanceSegment moves the head to the start of the segment after the current one,
+// deleting the finished segment.
+func (q *Queue) advanceSegment() error {
+	for i, s := range q.segments {
+		if s.id > q.head.seg {
+			q.head = Position{seg: s.id}
+			break
+		}
+		if i == len(q.segments)-1 {
+			return nil
+		}
+	}
+	for q.segments[0].id < q.head.seg {
+		q.grow(-q.segments[0].size)
+		if err := q.removeOldest(); err != nil {
+			return err
+		}
+	}
+	return q.writeCursor()
+}
+
+// Ack marks every record before pos as handled. Acknowledging a record that was
+// evicted in the meantime is a no-op.
+func (q *Queue) Ack(pos Position) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return ErrClosed
+	}
+	if pos.seg < q.head.seg || (pos.seg == q.head.seg && pos.off <= q.head.off) {
+		return nil
+	}
+	q.head = pos
+	return q.writeCursor()
+}
+
+func (q *Queue) readCursor() Position {
+	b, err := os.ReadFile(filepath.Join(q.opts.Dir, cursorFile))
+	if err != nil {
+		return Position{}
+	}
+	var p Position
+	if _, err := fmt.Sscanf(string(b), "%d %d", &p.seg, &p.off); err != nil {
+		return Position{}
+	}
+	return p
+}
+
+// writeCursor persists the head atomically via a temporary file.
+func (q *Queue) writeCursor() error {
+	path := filepath.Join(q.opts.Dir, cursorFile)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(fmt.Sprintf("%d %d\n", q.head.seg, q.head.off)), 0o600); err != nil {
+		return fmt.Errorf("diskqueue: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("diskqueue: %w", err)
+	}
+	return nil
+}
+
+// Sync writes appended records through to stable storage, unless Fsync is
+// FsyncNever.
+func (q *Queue) Sync() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return ErrClosed
+	}
+	return q.syncWriter(true)
+}
+
+// Empty reports whether every record has been acknowledged.
+func (q *Queue) Empty() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	w := q.segments[len(q.segments)-1]
+	return q.head.seg == w.id && q.head.off >= w.size
+}
+
+// Size returns the bytes held on disk, including acknowledged records of segments
+// that are still being read.
+func (q *Queue) Size() int64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.size
+}
+
+// EvictedBytes returns how many bytes were dropped to respect MaxBytes.
+func (q *Queue) EvictedBytes() int64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.evicted
+}
+
+// Close syncs and closes the queue; unacknowledged records remain for the next Open.
+// They no longer count towards its budget.
+func (q *Queue) Close() error {
+	b := q.opts.Budget
+	if b != nil {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return nil
+	}
+	q.closed = true
+	if b != nil {
+		delete(b.queues, q)
+		b.used.Add(-q.size)
+	}
+	close(q.notify)
+	if q.r != nil {
+		_ = q.r.Close()
+	}
+	err := q.syncWriter(true)
+	return errors.Join(err, q.w.Close())
+}
@@ -0,0 +1,185 @@
+package diskqueue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func mustOpen(t *testing.T, opts Options) *Queue {
+	t.Helper()
+	q, err := Open(opts)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	return q
+}
+
+func peek(t *testing.T, q *Queue) (string, Position) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	data, pos, err := q.Peek(ctx)
+	if err != nil {
+		t.Fatalf("peek: %v", err)
+	}
+	return string(data), pos
+}
+
+func TestQueue_OrderAckAndReopen(t *testing.T) {
+	opts := Options{Dir: t.TempDir(), MaxBytes: 1 << 20, SegmentBytes: 64, Fsync: FsyncAlways}
+	q := mustOpen(t, opts)
+	for i := 0; i < 10; i++ {
+		if err := q.Append([]byte(fmt.Sprintf("record-%02d", i))); err != nil {
+			t.Fatalf("append: %v", err)
+		}
+	}
+	// Peek without Ack returns the same record.
+	first, _ := peek(t, q)
+	again, pos := peek(t, q)
+	if first != "record-00" || again != first {
+		t.Fatalf("peek=%q then %q", first, again)
+	}
+	if err := q.Ack(pos); err != nil {
+		t.Fatalf("ack: %v", err)
+	}
+	for i := 1; i < 4; i++ {
+		got, pos := peek(t, q)
+		if want := fmt.Sprintf("record-%02d", i); got != want {
+			t.Fatalf("got %q want %q", got, want)
+		}
+		_ = q.Ack(pos)
+	}
+	_ = q.Close()
+
+	// Unacknowledged records survive a restart and are read in order.
+	q = mustOpen(t, opts)
+	defer func() { _ = q.Close() }()
+	_ = q.Append([]byte("after-restart"))
+	var got []string
+	for !q.Empty() {
+		data, pos := peek(t, q)
+		got = append(got, data)
+		_ = q.Ack(pos)
+	}
+	if len(got) != 7 || got[0] != "record-04" || got[5] != "record-09" || got[6] != "after-restart" {
+		t.Fatalf("replayed=%v", got)
+	}
+}
+
+func TestQueue_EvictsOldest(t *testing.T) {
+	// Each record takes 8+10 bytes; segments hold two records, the queue six.
+	var evicted []string
+	q := mustOpen(t, Options{Dir: t.TempDir(), MaxBytes: 108, SegmentBytes: 36, Fsync: FsyncNever, OnEvict: func(records [][]byte) {
+		for _, r := range records {
+			evicted = append(evicted, string(r))
+		}
+	}})
+	defer func() { _ = q.Close() }()
+	for i := 0; i < 10; i++ {
+		_ = q.Append([]byte(fmt.Sprintf("record-%03d", i)))
+	}
+	if q.Size() > 108 || q.EvictedBytes() == 0 {
+		t.Fatalf("size=%d evicted=%d", q.Size(), q.EvictedBytes())
+	}
+	got, pos := peek(t, q)
+	if got != "record-004" {
+		t.Fatalf("oldest after eviction=%q", got)
+	}
+
+	// Acknowledging a record that was evicted in the meantime does not move the head.
+	for i := 10; i < 14; i++ {
+		_ = q.Append([]byte(fmt.Sprintf("record-%03d", i)))
+	}
+	_ = q.Ack(pos)
+	if got, _ := peek(t, q); got != "record-008" {
+		t.Fatalf("head after stale ack=%q", got)
+	}
+	// Every record was reported when evicted, read or not, as none was acknowledged.
+	if len(evicted) != 8 || evicted[0] != "record-000" || evicted[7] != "record-007" {
+		t.Fatalf("evicted=%v", evicted)
+	}
+
+	// Acknowledged records are not reported.
+	for !q.Empty() {
+		_, pos := peek(t, q)
+		_ = q.Ack(pos)
+	}
+	evicted = nil
+	for i := 14; i < 20; i++ {
+		_ = q.Append([]byte(fmt.Sprintf("record-%03d", i)))
+	}
+	if len(evicted) != 0 {
+		t.Fatalf("evicted acknowledged records: %v", evicted)
+	}
+}
+
+func TestBudget_EvictsOldestAcrossQueues(t *testing.T) {
+	// Each record takes 8+10 bytes; segments hold two records, the budget six.
+	budget := NewBudget(108)
+	var evicted []string
+	onEvict := func(records [][]byte) {
+		for _, r := range records {
+			evicted = append(evicted, string(r))
+		}
+	}
+	a := mustOpen(t, Options{Dir: t.TempDir(), MaxBytes: 1 << 20, SegmentBytes: 36, Fsync: FsyncNever, OnEvict: onEvict, Budget: budget})
+	b := mustOpen(t, Options{Dir: t.TempDir(), MaxBytes: 1 << 20, SegmentBytes: 36, Fsync: FsyncNever, OnEvict: onEvict, Budget: budget})
+	defer func() { _ = b.Close() }()
+	for i := 0; i < 4; i++ {
+		_ = a.Append([]byte(fmt.Sprintf("a-record-%d", i)))
+	}
+	for i := 0; i < 4; i++ {
+		_ = b.Append([]byte(fmt.Sprintf("b-record-%d", i)))
+	}
+
+	// Appending to b evicted the oldest segment, which belongs to a.
+	if budget.Used() != 108 || a.Size()+b.Size() != 108 {
+		t.Fatalf("used=%d a=%d b=%d", budget.Used(), a.Size(), b.Size())
+	}
+	if len(evicted) != 2 || evicted[0] != "a-record-0" || evicted[1] != "a-record-1" {
+		t.Fatalf("evicted=%v", evicted)
+	}
+	if got, _ := peek(t, a); got != "a-record-2" {
+		t.Fatalf("oldest of a=%q", got)
+	}
+	if got, _ := peek(t, b); got != "b-record-0" {
+		t.Fatalf("oldest of b=%q", got)
+	}
+
+	// A closed queue no longer counts towards the budget.
+	_ = a.Close()
+	if budget.Used() != b.Size() {
+		t.Fatalf("used=%d after close, b=%d", budget.Used(), b.Size())
+	}
+}
+
+func TestQueue_PeekBlocksUntilAppendOrClose(t *testing.T) {
+	q := mustOpen(t, Options{Dir: t.TempDir(), MaxBytes: 1024, SegmentBytes: 512})
+	done := make(chan string, 1)
+	go func() {
+		data, _, err := q.Peek(context.Background())
+		if err != nil {
+			done <- err.Error()
+			return
+		}
+		done <- string(data)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	_ = q.Append([]byte("hello"))
+	select {
+	case got := <-done:
+		if got != "hello" {
+			t.Fatalf("peek=%q", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("peek did not wake up")
+	}
+
+	_ = q.Close()
+	if _, _, err := q.Peek(context.Background()); !errors.Is(err, ErrClosed) {
+		t.Fatalf("peek after close err=%v", err)
+	}
+}
@@ -0,0 +1,147 @@
+package driver
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/daemon/logger"
+	olog "go.opentelemetry.io/otel/log"
+)
+
+// Attribute schemas selectable through ATTRIBUTE_SCHEMA or the attribute-schema log-opt.
+const (
+	schemaLegacy  = "legacy"  // docker.* keys
+	schemaSemconv = "semconv" // OpenTelemetry semantic conventions
+	schemaBoth    = "both"
+)
+
+// containerAttributes returns the per-record attributes describing the container and
+// stream in the configured schema.
+func containerAttributes(info logger.Info, source, schema string) []olog.KeyValue {
+	var attrs []olog.KeyValue
+	if schema != schemaSemconv {
+		attrs = append(attrs,
+			olog.String("docker.container.id", info.ContainerID),
+			olog.String("docker.container.name", info.Name()),
+			olog.String("docker.image.name", info.ContainerImageName),
+			olog.String("docker.stream", source),
+		)
+	}
+	if schema == schemaSemconv || schema == schemaBoth {
+		img := parseImageRef(info.ContainerImageName)
+		attrs = append(attrs,
+			olog.String("container.id", info.ContainerID),
+			olog.String("container.name", info.Name()),
+			olog.String("container.image.name", img.name),
+		)
+		if img.tag != "" {
+			attrs = append(attrs, olog.Slice("container.image.tags", olog.StringValue(img.tag)))
+		}
+		if img.digest != "" {
+			attrs = append(attrs, olog.Slice("container.image.repo_digests", olog.StringValue(img.name+"@"+img.digest)))
+		}
+		if info.ContainerImageID != "" {
+			attrs = append(attrs, olog.String("container.image.id", info.ContainerImageID))
+		}
+		attrs = append(attrs, olog.String("log.iostream", source))
+	}
+	return attrs
+}
+
+// selectedAttributes returns the container labels and environment variables chosen by
+// the labels, labels-regex, env and env-regex log-opts (every label with include-labels).
+// Selection is delegated to Docker's ExtraAttributes so the options behave exactly as
+// they do for the built-in drivers; labels and env are resolved separately to key them
+// as docker.label.<key> / docker.env.<VAR> (container.label / container.env in semconv).
+func selectedAttributes(info logger.Info, opts containerOptions) ([]olog.KeyValue, error) {
+	labels := map[string]string{}
+	if opts.includeLabels {
+		labels = info.ContainerLabels
+	} else {
+		var err error
+		if labels, err = extraAttributes(info, "labels", "labels-regex"); err != nil {
+			return nil, err
+		}
+	}
+	env, err := extraAttributes(info, "env", "env-regex")
+	if err != nil {
+		return nil, err
+	}
+
+	var attrs []olog.KeyValue
+	for _, k := range sortedKeys(labels) {
+		for _, key := range labelKeys(k, opts.attributeSchema) {
+			attrs = append(attrs, olog.String(key, labels[k]))
+		}
+	}
+	for _, k := range sortedKeys(env) {
+		for _, key := range envKeys(k, opts.attributeSchema) {
+			attrs = append(attrs, olog.String(key, env[k]))
+		}
+	}
+	return attrs, nil
+}
+
+// extraAttributes runs Docker's ExtraAttributes restricted to the given log-opts.
+func extraAttributes(info logger.Info, optKeys ...string) (map[string]string, error) {
+	cfg := map[string]string{}
+	for _, k := range optKeys {
+		if v, ok := info.Config[k]; ok {
+			cfg[k] = v
+		}
+	}
+	info.Config = cfg
+	return info.ExtraAttributes(nil)
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// labelKeys returns the attribute keys of a container label in the configured schema.
+func labelKeys(key, schema string) []string {
+	switch schema {
+	case schemaSemconv:
+		return []string{"container.label." + key}
+	case schemaBoth:
+		return []string{"docker.label." + key, "container.label." + key}
+	}
+	return []string{"docker.label." + key}
+}
+
+// envKeys returns the attribute keys of a container environment variable.
+func envKeys(key, schema string) []string {
+	switch schema {
+	case schemaSemconv:
+		return []string{"container.env." + key}
+	case schemaBoth:
+		return []string{"docker.env." + key, "container.env." + key}
+	}
+	return []string{"docker.env." + key}
+}
+
+type imageRef struct {
+	name   string
+	tag    string
+	digest string
+}
+
+// parseImageRef splits an image reference such as registry:5000/org/app:v1@sha256:...
+// into repository name, tag and digest.
+func parseImageRef(ref string) imageRef {
+	var img imageRef
+	if name, digest, ok := strings.Cut(ref, "@"); ok {
+		ref, img.digest = name, digest
+	}
+	// A colon after the last slash separates the tag; earlier ones belong to a registry port.
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		ref, img.tag = ref[:i], ref[i+1:]
+	}
+	img.name = ref
+	return img
+}
@@ -0,0 +1,129 @@
+package driver
+
+import (
+	"testing"
+
+	"github.com/docker/docker/daemon/logger"
+	olog "go.opentelemetry.io/otel/log"
+)
+
+func TestParseImageRef(t *testing.T) {
+	cases := map[string]imageRef{
+		"nginx":                               {name: "nginx"},
+		"nginx:1.27":                          {name: "nginx", tag: "1.27"},
+		"registry:5000/org/app":               {name: "registry:5000/org/app"},
+		"registry:5000/org/app:v1":            {name: "registry:5000/org/app", tag: "v1"},
+		"ghcr.io/org/app:v1@sha256:abc":       {name: "ghcr.io/org/app", tag: "v1", digest: "sha256:abc"},
+		"app@sha256:0123":                     {name: "app", digest: "sha256:0123"},
+		"localhost:5000/app@sha256:0123":      {name: "localhost:5000/app", digest: "sha256:0123"},
+		"docker.io/library/busybox:1.36-musl": {name: "docker.io/library/busybox", tag: "1.36-musl"},
+	}
+	for in, want := range cases {
+		if got := parseImageRef(in); got != want {
+			t.Fatalf("parseImageRef(%q)=%+v want %+v", in, got, want)
+		}
+	}
+}
+
+func attrValues(kvs []olog.KeyValue) map[string]olog.Value {
+	m := map[string]olog.Value{}
+	for _, kv := range kvs {
+		m[kv.Key] = kv.Value
+	}
+	return m
+}
+
+func TestContainerAttributes_Schemas(t *testing.T) {
+	info := logger.Info{
+		ContainerID:        "cid123",
+		ContainerName:      "/demo",
+		ContainerImageName: "ghcr.io/org/app:v1@sha256:abc",
+		ContainerImageID:   "sha256:def",
+	}
+
+	legacy := attrValues(containerAttributes(info, "stdout", schemaLegacy))
+	if legacy["docker.container.id"].AsString() != "cid123" || legacy["docker.stream"].AsString() != "stdout" {
+		t.Fatalf("legacy=%v", legacy)
+	}
+	if _, ok := legacy["container.id"]; ok {
+		t.Fatalf("legacy contains semconv keys: %v", legacy)
+	}
+
+	sem := attrValues(containerAttributes(info, "stderr", schemaSemconv))
+	if _, ok := sem["docker.container.id"]; ok {
+		t.Fatalf("semconv contains legacy keys: %v", sem)
+	}
+	if sem["container.id"].AsString() != "cid123" ||
+		sem["container.name"].AsString() != "demo" ||
+		sem["container.image.name"].AsString() != "ghcr.io/org/app" ||
+		sem["container.image.id"].AsString() != "sha256:def" ||
+		sem["log.iostream"].AsString() != "stderr" {
+		t.Fatalf("semconv=%v", sem)
+	}
+	if tags := sem["container.image.tags"].AsSlice(); len(tags) != 1 || tags[0].AsString() != "v1" {
+		t.Fatalf("tags=%v", sem["container.image.tags"])
+	}
+	if d := sem["container.image.repo_digests"].AsSlice(); len(d) != 1 || d[0].AsString() != "ghcr.io/org/app@sha256:abc" {
+		t.Fatalf("digests=%v", sem["container.image.repo_digests"])
+	}
+
+	both := attrValues(containerAttributes(info, "stdout", schemaBoth))
+	if both["docker.container.id"].AsString() != "cid123" || both["container.id"].AsString() != "cid123" {
+		t.Fatalf("both=%v", both)
+	}
+}
+
+func TestSelectedAttributes(t *testing.T) {
+	info := logger.Info{
+		ContainerLabels: map[string]string{
+			"com.example.team":           "payments",
+			"com.example.tier":           "backend",
+			"com.docker.compose.project": "shop",
+			"org.opencontainers.image":   "x",
+		},
+		ContainerEnv: []string{"VERSION=1.2.3", "REGION=eu", "SECRET=s3cr3t", "NOVALUE"},
+	}
+
+	info.Config = map[string]string{"labels": "com.example.team", "labels-regex": `^com\.docker\.`, "env": "VERSION", "env-regex": "^REG"}
+	opts, _ := parseOptions(info.Config)
+	opts.attributeSchema = schemaLegacy
+	kvs, err := selectedAttributes(info, opts)
+	if err != nil {
+		t.Fatalf("selectedAttributes: %v", err)
+	}
+	got := attrValues(kvs)
+	if len(got) != 4 ||
+		got["docker.label.com.example.team"].AsString() != "payments" ||
+		got["docker.label.com.docker.compose.project"].AsString() != "shop" ||
+		got["docker.env.VERSION"].AsString() != "1.2.3" ||
+		got["docker.env.REGION"].AsString() != "eu" {
+		t.Fatalf("legacy=%v", got)
+	}
+
+	opts.attributeSchema = schemaSemconv
+	kvs, _ = selectedAttributes(info, opts)
+	got = attrValues(kvs)
+	if got["container.label.com.example.team"].AsString() != "payments" || got["container.env.VERSION"].AsString() != "1.2.3" {
+		t.Fatalf("semconv=%v", got)
+	}
+
+	// include-labels keeps attaching every label.
+	info.Config = map[string]string{"include-labels": "true", "labels": "com.example.team"}
+	opts, _ = parseOptions(info.Config)
+	kvs, _ = selectedAttributes(info, opts)
+	if len(kvs) != len(info.ContainerLabels) {
+		t.Fatalf("include-labels=%v", kvs)
+	}
+
+	// Nothing selected by default.
+	info.Config = nil
+	opts, _ = parseOptions(nil)
+	if kvs, _ = selectedAttributes(info, opts); len(kvs) != 0 {
+		t.Fatalf("default=%v", kvs)
+	}
+
+	info.Config = map[string]string{"env-regex": "("}
+	if _, err := selectedAttributes(info, opts); err == nil {
+		t.Fatalf("invalid env-regex accepted")
+	}
+}
@@ -0,0 +1,90 @@
+package driver
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/docker/docker/api/types/plugins/logdriver"
+)
+
+const (
+	bufferBlock      = "block"
+	bufferDropNewest = "drop-newest"
+	bufferDropOldest = "drop-oldest"
+
+	defaultBufferPolicy = bufferBlock
+	defaultBufferSize   = 1000
+)
+
+// recordBuffer is the bounded queue between reading a container's FIFO and emitting
+// its records. When it is full, the policy decides whether the reader waits (and with
+// it Docker and the application writing the FIFO) or a record is dropped.
+type recordBuffer struct {
+	policy  string
+	ch      chan *logdriver.LogEntry
+	dropped atomic.Int64
+}
+
+func newRecordBuffer(policy string, size int) *recordBuffer {
+	return &recordBuffer{policy: policy, ch: make(chan *logdriver.LogEntry, size)}
+}
+
+// Push queues a complete record and returns how many records were dropped to do so.
+// With the block policy it waits for room until ctx is done, in which case the record
+// itself is dropped.
+func (b *recordBuffer) Push(ctx context.Context, e *logdriver.LogEntry) int64 {
+	select {
+	case b.ch <- e:
+		return 0
+	default:
+	}
+	var dropped int64
+	switch b.policy {
+	case bufferDropNewest:
+		dropped = 1
+	case bufferDropOldest:
+	loop:
+		for {
+			select {
+			case b.ch <- e:
+				break loop
+			default:
+			}
+			select {
+			case <-b.ch:
+				dropped++
+			default:
+			}
+		}
+	default:
+		select {
+		case b.ch <- e:
+		case <-ctx.Done():
+			dropped = 1
+		}
+	}
+	b.dropped.Add(dropped)
+	return dropped
+}
+
+// Close ends the buffer; records already queued are still delivered by Drain.
+func (b *recordBuffer) Close() {
+	close(b.ch)
+}
+
+// Drain calls emit for every record until the buffer is closed and empty.
+func (b *recordBuffer) Drain(emit func(*logdriver.LogEntry)) {
+	for e := range b.ch {
+		emit(e)
+	}
+}
+
+// Len returns the number of queued records.
+func (b *recordBuffer) Len() int {
+	return len(b.ch)
+}
+
+// Dropped returns how many records the policy discarded.
+func (b *recordBuffer) Dropped() int64 {
+	return b.dropped.Load()
+}
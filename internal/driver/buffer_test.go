@@ -0,0 +1,68 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/plugins/logdriver"
+)
+
+func fillBuffer(b *recordBuffer, n int) {
+	for i := 0; i < n; i++ {
+		b.Push(context.Background(), &logdriver.LogEntry{Line: []byte(fmt.Sprint(i))})
+	}
+}
+
+func drained(b *recordBuffer) string {
+	b.Close()
+	var lines []string
+	b.Drain(func(e *logdriver.LogEntry) { lines = append(lines, string(e.Line)) })
+	return strings.Join(lines, ",")
+}
+
+func TestRecordBuffer_DropPolicies(t *testing.T) {
+	newest := newRecordBuffer(bufferDropNewest, 3)
+	fillBuffer(newest, 5)
+	if got := drained(newest); got != "0,1,2" || newest.Dropped() != 2 {
+		t.Fatalf("drop-newest kept %q, dropped %d", got, newest.Dropped())
+	}
+
+	oldest := newRecordBuffer(bufferDropOldest, 3)
+	fillBuffer(oldest, 5)
+	if got := drained(oldest); got != "2,3,4" || oldest.Dropped() != 2 {
+		t.Fatalf("drop-oldest kept %q, dropped %d", got, oldest.Dropped())
+	}
+}
+
+func TestRecordBuffer_BlockWaitsForRoom(t *testing.T) {
+	b := newRecordBuffer(bufferBlock, 1)
+	fillBuffer(b, 1)
+
+	pushed := make(chan struct{})
+	go func() {
+		fillBuffer(b, 1)
+		close(pushed)
+	}()
+	select {
+	case <-pushed:
+		t.Fatalf("push into a full buffer did not block")
+	case <-time.After(20 * time.Millisecond):
+	}
+	<-b.ch
+	select {
+	case <-pushed:
+	case <-time.After(time.Second):
+		t.Fatalf("push did not resume once there was room")
+	}
+
+	// A cancelled reader gives up and counts the record as dropped.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	b.Push(ctx, &logdriver.LogEntry{})
+	if b.Dropped() != 1 || b.Len() != 1 {
+		t.Fatalf("dropped=%d len=%d", b.Dropped(), b.Len())
+	}
+}
@@ -4,11 +4,15 @@ import (
 	"context"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
-	"os"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -18,8 +22,10 @@ import (
 	"github.com/docker/go-plugins-helpers/sdk"
 	protoio "github.com/gogo/protobuf/io"
 
+	"go.opentelemetry.io/otel/attribute"
 	olog "go.opentelemetry.io/otel/log"
-	"go.opentelemetry.io/otel/log/global"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/moritzloewenstein/otel-docker-logging-driver/internal/config"
 	"github.com/moritzloewenstein/otel-docker-logging-driver/internal/otelx"
@@ -46,50 +52,183 @@ type ReadLogsRequest struct {
 
 type response struct{ Err string }
 
+// StatusResponse is served by /OtelLogs.Status on the plugin socket.
+type StatusResponse struct {
+	// Degraded is set when the plugin runs despite configuration problems
+	Degraded bool             `json:"degraded"`
+	Problems []config.Problem `json:"problems"`
+	// Containers whose FIFO is being consumed
+	Containers []ContainerStatus `json:"containers"`
+}
+
+// ContainerStatus is the pipeline state of one tracked FIFO.
+type ContainerStatus struct {
+	File          string `json:"file"`
+	ContainerID   string `json:"container_id"`
+	ContainerName string `json:"container_name"`
+	// Effective exporter and log-opts; headers are left out as they may hold credentials
+	Endpoint string            `json:"endpoint"`
+	Protocol string            `json:"protocol"`
+	Options  map[string]string `json:"options"`
+	Started  time.Time         `json:"started"`
+	// Docker timestamp of the last complete line read
+	LastLine     time.Time `json:"last_line,omitzero"`
+	Received     int64     `json:"received"`
+	Emitted      int64     `json:"emitted"`
+	Dropped      int64     `json:"dropped"`
+	DecodeErrors int64     `json:"decode_errors"`
+	BufferDepth  int       `json:"buffer_depth"`
+	// Latest export requests to the container's endpoint
+	Export otelx.EndpointState `json:"export"`
+	// Why the exporter could not be set up; records are then only kept locally
+	ExporterError string `json:"exporter_error,omitempty"`
+}
+
+var errShuttingDown = errors.New("plugin is shutting down")
+
+const (
+	// Bound of StopLogging when the config does not set one
+	defaultStopTimeout = 5 * time.Second
+	// How long the FIFO of a stopping container has to be quiet to count as drained
+	stopQuietPeriod = 100 * time.Millisecond
+)
+
 // Driver is the core logging driver implementation.
 type Driver struct {
-	mu   sync.Mutex
-	logs map[string]*dockerInput
-	cfg  config.Config
+	mu      sync.Mutex
+	logs    map[string]*dockerInput
+	cfg     config.Config
+	pool    *otelx.Pool
+	metrics *otelx.Metrics
+	// Configuration problems the plugin was started with in degraded mode
+	problems []config.Problem
+	// Set by Shutdown; StartLogging is refused from then on
+	closing bool
+	// Stores in LogDir, nil if the local store is disabled
+	logDir *logDir
+	// Closed by Shutdown to stop pruning LogDir
+	stopPrune chan struct{}
 }
 
 type dockerInput struct {
 	stream io.ReadCloser
 	info   logger.Info
+	// Logs about the container, with its ID, name and FIFO as fields
+	log   *slog.Logger
+	opts  containerOptions
+	store *logStore
+	// Nil in degraded mode when the exporter could not be set up; exportErr says why
+	lease     *otelx.Lease
+	exportErr error
+	buffer    *recordBuffer
+	// Effective exporter config, as resolved from the log-opts
+	exporterCfg config.Config
+	// Identifies the container on per-container metrics
+	metricAttrs metric.MeasurementOption
+	stats       inputStats
+	// Attributes fixed for the container's lifetime (selected labels and env)
+	attrs  []olog.KeyValue
 	cancel context.CancelFunc
+	// Closed by StopLogging to have consume finish once the FIFO is drained
+	stopping chan struct{}
+	stopOnce sync.Once
+	// Unix nanoseconds until which StopLogging waits for the records to be flushed
+	stopDeadline atomic.Int64
+	// Closed when consume has returned
+	done chan struct{}
 }
 
-func New(cfg config.Config, _ any) *Driver {
-	return &Driver{logs: make(map[string]*dockerInput), cfg: cfg}
+// inputStats are the counters of a container reported by Status.
+type inputStats struct {
+	started      time.Time
+	lastLine     atomic.Int64
+	received     atomic.Int64
+	emitted      atomic.Int64
+	decodeErrors atomic.Int64
+	// Records read from the FIFO but discarded because the stop deadline expired
+	abandoned atomic.Int64
+}
+
+// New creates the driver. metrics may be nil to record nothing.
+func New(cfg config.Config, pool *otelx.Pool, metrics *otelx.Metrics) *Driver {
+	if metrics == nil {
+		metrics = otelx.NoopMetrics()
+	}
+	d := &Driver{logs: make(map[string]*dockerInput), cfg: cfg, pool: pool, metrics: metrics, problems: cfg.Validate()}
+	if cfg.LogDir != "" {
+		d.logDir = newLogDir(cfg.LogDir)
+		d.stopPrune = make(chan struct{})
+		go d.pruneLogDir()
+	}
+	_, _ = metrics.ObserveBufferDepth(func(report func(id, name string, depth int64)) {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		for _, in := range d.logs {
+			report(in.info.ContainerID, in.info.ContainerName, int64(in.buffer.Len()))
+		}
+	})
+	return d
 }
 
 func RegisterHandlers(h *sdk.Handler, d *Driver) {
 	h.HandleFunc("/LogDriver.StartLogging", func(w http.ResponseWriter, r *http.Request) {
 		var req StartLoggingRequest
 		_ = json.NewDecoder(r.Body).Decode(&req)
-		_, _ = fmt.Fprintf(os.Stdout, "StartLogging: container=%s file=%s\n", req.Info.ContainerID, req.File)
+		slog.Info("start logging", "container_id", req.Info.ContainerID, "fifo", req.File)
 		err := d.StartLogging(req.File, req.Info)
+		if err != nil {
+			slog.Error("cannot start logging", "container_id", req.Info.ContainerID, "fifo", req.File, "error", err)
+		}
 		writeResp(w, err)
 	})
 
 	h.HandleFunc("/LogDriver.StopLogging", func(w http.ResponseWriter, r *http.Request) {
 		var req StopLoggingRequest
 		_ = json.NewDecoder(r.Body).Decode(&req)
-		_, _ = fmt.Fprintf(os.Stdout, "StopLogging: file=%s\n", req.File)
+		slog.Info("stop logging", "fifo", req.File)
 		err := d.StopLogging(req.File)
 		writeResp(w, err)
 	})
 
 	h.HandleFunc("/LogDriver.Capabilities", func(w http.ResponseWriter, r *http.Request) {
-		_ = json.NewEncoder(w).Encode(&CapabilitiesResponse{Cap: logger.Capability{ReadLogs: false}})
+		_ = json.NewEncoder(w).Encode(&CapabilitiesResponse{Cap: logger.Capability{ReadLogs: d.cfg.LogDir != ""}})
+	})
+
+	h.HandleFunc("/OtelLogs.Status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(d.Status())
 	})
 
 	h.HandleFunc("/LogDriver.ReadLogs", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusNotImplemented)
-		_, _ = w.Write([]byte(`{"Err":"not implemented"}`))
+		var req ReadLogsRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if d.cfg.LogDir == "" {
+			http.Error(w, "reading logs is disabled: LOG_DIR is not set", http.StatusNotImplemented)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-json-stream")
+		if err := d.ReadLogs(r.Context(), req.Info, req.Config, &flushWriter{w: w}); err != nil {
+			slog.Error("cannot read logs", "container_id", req.Info.ContainerID, "error", err)
+		}
 	})
 }
 
+// flushWriter flushes after every write so followed entries reach Docker immediately.
+type flushWriter struct {
+	w http.ResponseWriter
+}
+
+func (fw *flushWriter) Write(p []byte) (int, error) {
+	n, err := fw.w.Write(p)
+	if f, ok := fw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return n, err
+}
+
 func writeResp(w http.ResponseWriter, err error) {
 	var res response
 	if err != nil {
@@ -98,95 +237,505 @@ func writeResp(w http.ResponseWriter, err error) {
 	_ = json.NewEncoder(w).Encode(&res)
 }
 
+// Status reports the configuration problems the plugin is running with and the state
+// of every container being logged.
+func (d *Driver) Status() StatusResponse {
+	st := StatusResponse{
+		Degraded:   len(d.problems) > 0,
+		Problems:   append([]config.Problem{}, d.problems...),
+		Containers: []ContainerStatus{},
+	}
+	d.mu.Lock()
+	for file, in := range d.logs {
+		st.Containers = append(st.Containers, in.status(file, d.metrics))
+	}
+	d.mu.Unlock()
+	sort.Slice(st.Containers, func(i, j int) bool { return st.Containers[i].File < st.Containers[j].File })
+	return st
+}
+
+func (in *dockerInput) status(file string, metrics *otelx.Metrics) ContainerStatus {
+	opts := map[string]string{}
+	for k, v := range in.info.Config {
+		if k != "headers" {
+			opts[k] = v
+		}
+	}
+	for k, v := range in.opts.values {
+		opts[k] = v
+	}
+	protocol := in.exporterCfg.Protocol
+	if protocol == "" {
+		protocol = "grpc"
+	}
+	var lastLine time.Time
+	if n := in.stats.lastLine.Load(); n != 0 {
+		lastLine = time.Unix(0, n)
+	}
+	return ContainerStatus{
+		File:          file,
+		ContainerID:   in.info.ContainerID,
+		ContainerName: strings.TrimPrefix(in.info.ContainerName, "/"),
+		Endpoint:      in.exporterCfg.Endpoint,
+		Protocol:      protocol,
+		Options:       opts,
+		Started:       in.stats.started,
+		LastLine:      lastLine,
+		Received:      in.stats.received.Load(),
+		Emitted:       in.stats.emitted.Load(),
+		Dropped:       in.buffer.Dropped(),
+		DecodeErrors:  in.stats.decodeErrors.Load(),
+		BufferDepth:   in.buffer.Len(),
+		Export:        metrics.EndpointState(in.exporterCfg.Endpoint),
+		ExporterError: errorString(in.exportErr),
+	}
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
 func (d *Driver) StartLogging(file string, info logger.Info) error {
 	d.mu.Lock()
+	if d.closing {
+		d.mu.Unlock()
+		return errShuttingDown
+	}
 	if _, exists := d.logs[file]; exists {
 		d.mu.Unlock()
 		return fmt.Errorf("logger for %q already exists", file)
 	}
 	d.mu.Unlock()
 
+	in, err := d.newInput(info)
+	if err != nil {
+		return err
+	}
+
 	f, err := fifo.OpenFifo(context.Background(), file, syscall.O_RDONLY, 0700)
 	if err != nil {
+		in.close()
 		return fmt.Errorf("open fifo %q: %w", file, err)
 	}
+	in.stream = f
+	in.log = in.log.With("fifo", file)
+	for _, w := range d.warnings(in) {
+		in.log.Warn(w)
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
+	in.cancel = cancel
 
+	// Shutdown may have started, or the same FIFO been registered, while the input
+	// was set up; Shutdown only stops the inputs it finds in d.logs.
 	d.mu.Lock()
-	d.logs[file] = &dockerInput{stream: f, info: info, cancel: cancel}
+	_, exists := d.logs[file]
+	if d.closing || exists {
+		d.mu.Unlock()
+		cancel()
+		_ = in.stream.Close()
+		in.close()
+		if exists {
+			return fmt.Errorf("logger for %q already exists", file)
+		}
+		return errShuttingDown
+	}
+	d.logs[file] = in
 	d.mu.Unlock()
 
-	go d.consume(ctx, f, info)
+	go d.consume(ctx, in)
 	return nil
 }
 
+// StopLogging lets the container's records still in the FIFO be read, and flushes
+// them together with those waiting in the processor, before it returns. After
+// StopTimeout it gives up and abandons what is left.
 func (d *Driver) StopLogging(file string) error {
 	d.mu.Lock()
-	defer d.mu.Unlock()
-	lf, ok := d.logs[file]
-	if ok {
-		lf.cancel()
-		_ = lf.stream.Close()
-		delete(d.logs, file)
+	in, ok := d.logs[file]
+	d.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	timeout := d.cfg.StopTimeout
+	if timeout <= 0 {
+		timeout = defaultStopTimeout
 	}
+	d.stop(file, in, time.Now().Add(timeout))
 	return nil
 }
 
-func (d *Driver) consume(ctx context.Context, r io.ReadCloser, info logger.Info) {
-	dec := protoio.NewUint32DelimitedReader(r, binary.BigEndian, 1e6)
-	defer func() { _ = dec.Close() }()
-	var entry logdriver.LogEntry
+// Shutdown refuses further StartLogging calls and stops every container as
+// StopLogging does, all within ctx. It returns how many records read from the FIFOs
+// were abandoned before reaching an exporter, and ctx's error if some containers
+// could not be drained and flushed in time. The plugin socket stays up meanwhile, so
+// Docker can still stop containers itself.
+func (d *Driver) Shutdown(ctx context.Context) (int64, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(defaultStopTimeout)
+	}
+	d.mu.Lock()
+	if !d.closing && d.stopPrune != nil {
+		close(d.stopPrune)
+	}
+	d.closing = true
+	logs := make(map[string]*dockerInput, len(d.logs))
+	for file, in := range d.logs {
+		logs[file] = in
+	}
+	d.mu.Unlock()
+
+	var wg sync.WaitGroup
+	var expired atomic.Bool
+	var abandoned atomic.Int64
+	for file, in := range logs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if !d.stop(file, in, deadline) {
+				expired.Store(true)
+			}
+			abandoned.Add(in.stats.abandoned.Load())
+		}()
+	}
+	wg.Wait()
+	if expired.Load() {
+		return abandoned.Load(), context.DeadlineExceeded
+	}
+	return abandoned.Load(), nil
+}
+
+// stop has the container's consume goroutine drain the FIFO and flush its records
+// until deadline, then abandons what is left and stops tracking file. It reports
+// whether the container finished in time.
+func (d *Driver) stop(file string, in *dockerInput, deadline time.Time) bool {
+	in.stop(deadline)
+	t := time.NewTimer(time.Until(deadline))
+	defer t.Stop()
+	inTime := true
+	select {
+	case <-in.done:
+	case <-t.C:
+		inTime = false
+	}
+	in.cancel()
+	_ = in.stream.Close()
+	<-in.done
+	if !inTime {
+		in.log.Warn("stop timeout expired, records abandoned", "count", in.stats.abandoned.Load())
+	}
+
+	d.mu.Lock()
+	if d.logs[file] == in {
+		delete(d.logs, file)
+	}
+	d.mu.Unlock()
+	return inTime
+}
+
+// warnings lists settings of a container that are accepted but probably not meant
+// as they are; StartLogging logs each of them once.
+func (d *Driver) warnings(in *dockerInput) []string {
+	var out []string
+	_, ownHeaders := in.info.Config["headers"]
+	if in.exporterCfg.Endpoint != d.cfg.Endpoint && !ownHeaders && len(d.cfg.Headers) > 0 {
+		out = append(out, "plugin-level headers are not sent to the endpoint set by the endpoint log-opt; set the headers log-opt if it needs them")
+	}
+	if len(in.opts.parseAttributes) > 0 && in.opts.parse == "" {
+		out = append(out, "parse-attributes has no effect without the parse log-opt")
+	}
+	if len(in.opts.timestampFields) > 0 && in.opts.parse != "json" && in.opts.parse != "logfmt" {
+		out = append(out, "timestamp-field has no effect without parse=json or parse=logfmt")
+	}
+	return out
+}
 
-	otelLogger := global.Logger("otel-docker-logging-driver")
+// newInput resolves the options and exporter of a container and opens its local store.
+func (d *Driver) newInput(info logger.Info) (*dockerInput, error) {
+	opts, err := parseOptions(info.Config)
+	if err != nil {
+		return nil, err
+	}
+	if opts.attributeSchema == "" {
+		opts.attributeSchema = d.cfg.AttributeSchema
+	}
+	attrs, err := selectedAttributes(info, opts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid labels/env selection: %w", err)
+	}
+	exporterCfg, err := d.cfg.WithLogOpts(info.Config)
+	if err != nil {
+		return nil, err
+	}
+	// In degraded mode a container whose exporter cannot be set up is still accepted;
+	// its records only go to the local store.
+	lease, exportErr := d.pool.Acquire(context.Background(), exporterCfg, containerResource(info, opts))
+	if exportErr != nil && !d.cfg.Degraded {
+		return nil, fmt.Errorf("setup exporter for container %s: %w", info.ContainerID, exportErr)
+	}
+	in := &dockerInput{
+		info:        info,
+		log:         slog.With("container_id", info.ContainerID, "container_name", strings.TrimPrefix(info.ContainerName, "/")),
+		opts:        opts,
+		lease:       lease,
+		exportErr:   exportErr,
+		attrs:       attrs,
+		buffer:      newRecordBuffer(opts.bufferPolicy, opts.bufferSize),
+		exporterCfg: exporterCfg,
+		metricAttrs: otelx.ContainerAttributes(info.ContainerID, info.ContainerName),
+		cancel:      func() {},
+		stopping:    make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	in.stats.started = time.Now()
+	if exportErr != nil {
+		in.log.Error("cannot setup exporter, records are not exported", "endpoint", exporterCfg.Endpoint, "error", exportErr)
+	}
+	if d.cfg.LogDir != "" {
+		in.store, err = d.logDir.openStore(info.ContainerID, opts.localMaxSize, opts.localMaxFiles)
+		if err != nil {
+			in.close()
+			return nil, err
+		}
+	}
+	return in, nil
+}
+
+// stop has consume finish once the FIFO is drained, and flush until deadline.
+func (in *dockerInput) stop(deadline time.Time) {
+	in.stopOnce.Do(func() {
+		in.stopDeadline.Store(deadline.UnixNano())
+		close(in.stopping)
+	})
+}
 
+// close flushes and releases the exporter lease and closes the local store once
+// consuming has finished, within the deadline set by StopLogging if there is one.
+func (in *dockerInput) close() {
+	if in.store != nil {
+		_ = in.store.Close()
+	}
+	deadline := time.Now().Add(defaultStopTimeout)
+	if n := in.stopDeadline.Load(); n != 0 {
+		deadline = time.Unix(0, n)
+	}
+	if in.lease == nil {
+		return
+	}
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	_ = in.lease.Release(ctx)
+}
+
+// pruneLogDir removes the stores of containers that stopped logging, per LOG_RETENTION
+// and LOG_MAX_SIZE, right away and then periodically until Shutdown.
+func (d *Driver) pruneLogDir() {
+	t := time.NewTicker(pruneInterval)
+	defer t.Stop()
 	for {
+		removed, err := d.logDir.prune(d.cfg.LogRetention, d.cfg.LogMaxSize, time.Now())
+		for _, id := range removed {
+			slog.Info("removed local log store", "container_id", id)
+		}
+		if err != nil {
+			slog.Error("cannot prune local log store", "dir", d.cfg.LogDir, "error", err)
+		}
 		select {
-		case <-ctx.Done():
+		case <-t.C:
+		case <-d.stopPrune:
 			return
-		default:
 		}
+	}
+}
+
+// ReadLogs streams the locally stored entries of a container to w.
+func (d *Driver) ReadLogs(ctx context.Context, info logger.Info, cfg logger.ReadConfig, w io.Writer) error {
+	return readLogs(ctx, d.cfg.LogDir, info.ContainerID, cfg, w, func() bool {
+		return d.isLogging(info.ContainerID)
+	})
+}
+
+// isLogging reports whether a FIFO of the given container is still being consumed.
+func (d *Driver) isLogging(containerID string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, in := range d.logs {
+		if in.info.ContainerID == containerID {
+			return true
+		}
+	}
+	return false
+}
 
-		if err := dec.ReadMsg(&entry); err != nil {
-			if err == io.EOF || err == io.ErrClosedPipe {
+func (d *Driver) consume(ctx context.Context, in *dockerInput) {
+	defer close(in.done)
+	defer in.close()
+	d.metrics.ActiveContainers.Add(ctx, 1, in.metricAttrs)
+	defer d.metrics.ActiveContainers.Add(context.Background(), -1, in.metricAttrs)
+
+	entries := make(chan *logdriver.LogEntry)
+	go decodeEntries(ctx, in.stream, entries, func() {
+		in.stats.decodeErrors.Add(1)
+		d.metrics.DecodeErrors.Add(context.Background(), 1, in.metricAttrs)
+	})
+
+	// Records are emitted from their own goroutine so a slow exporter only affects
+	// reading the FIFO as far as the buffer policy allows.
+	var otelLogger olog.Logger
+	if in.lease != nil {
+		otelLogger = in.lease.Logger()
+	}
+	emitted := make(chan struct{})
+	go func() {
+		defer close(emitted)
+		in.buffer.Drain(func(rec *logdriver.LogEntry) {
+			if in.lease == nil {
+				// Degraded: the record is only in the local store.
 				return
 			}
-			// Recreate reader on transient error.
-			dec = protoio.NewUint32DelimitedReader(r, binary.BigEndian, 1e6)
-			continue
+			if ctx.Err() != nil {
+				// The stop deadline expired: what is left in the buffer is abandoned.
+				in.stats.abandoned.Add(1)
+				d.metrics.Dropped.Add(context.Background(), 1, in.metricAttrs, metric.WithAttributes(attribute.String("reason", otelx.DropStopped)))
+				return
+			}
+			d.emit(otelLogger, in, rec)
+			in.stats.emitted.Add(1)
+			d.metrics.Emitted.Add(context.Background(), 1, in.metricAttrs)
+		})
+	}()
+	defer func() {
+		in.buffer.Close()
+		<-emitted
+		if n := in.buffer.Dropped(); n > 0 {
+			in.log.Warn("dropped records", "count", n, "buffer_policy", in.opts.bufferPolicy)
+		}
+	}()
+	push := func(rec *logdriver.LogEntry) {
+		if n := in.buffer.Push(ctx, rec); n > 0 {
+			reason := otelx.DropBufferFull
+			if ctx.Err() != nil {
+				reason = otelx.DropStopped
+				in.stats.abandoned.Add(n)
+			}
+			d.metrics.Dropped.Add(context.Background(), n, in.metricAttrs, metric.WithAttributes(attribute.String("reason", reason)))
 		}
+	}
+
+	partials := newPartialAssembler(in.opts.partialMaxSize, in.opts.partialFlushTimeout)
+	lines := newMultilineAggregator(in.opts)
+	ticker := time.NewTicker(min(in.opts.partialFlushTimeout, in.opts.multilineFlushTimeout, time.Second))
+	defer ticker.Stop()
 
-		// Map Docker entry to OTEL log record.
-		severity := olog.SeverityInfo
-		if entry.Source == "stderr" {
-			severity = olog.SeverityError
+	// Complete lines go through multiline aggregation.
+	handle := func(complete []*logdriver.LogEntry, now time.Time) {
+		for _, e := range complete {
+			in.stats.received.Add(1)
+			ts := e.TimeNano
+			if ts == 0 {
+				ts = now.UnixNano()
+			}
+			in.stats.lastLine.Store(ts)
+			d.metrics.Received.Add(context.Background(), 1, in.metricAttrs)
+			for _, rec := range lines.Add(e, now) {
+				push(rec)
+			}
 		}
-		// Base attributes
-		attrs := []olog.KeyValue{
-			olog.String("docker.container.id", info.ContainerID),
-			olog.String("docker.container.name", info.Name()),
-			olog.String("docker.image.name", info.ContainerImageName),
-			olog.String("docker.stream", entry.Source),
+	}
+
+	finish := func() {
+		handle(partials.Flush(), time.Now())
+		for _, rec := range lines.Flush() {
+			push(rec)
 		}
+	}
 
-		// Per-container options from --log-opt
-		if v, ok := info.Config["include-labels"]; ok && (v == "1" || v == "true" || v == "yes") {
-			for k, val := range info.ContainerLabels {
-				attrs = append(attrs, olog.String("docker.label."+k, val))
+	// Docker closes its end of the FIFO only after StopLogging returned, so once
+	// stopping the FIFO counts as drained when it has been quiet for a moment.
+	stopping := in.stopping
+	var quiet *time.Timer
+	var drained <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-stopping:
+			stopping = nil
+			quiet = time.NewTimer(stopQuietPeriod)
+			defer quiet.Stop()
+			drained = quiet.C
+		case <-drained:
+			finish()
+			return
+		case entry, ok := <-entries:
+			if !ok {
+				finish()
+				return
+			}
+			// The local store keeps Docker's fragments as they are: reassembled lines
+			// can exceed the frame size Docker reads back.
+			d.store(in, entry)
+			now := time.Now()
+			handle(partials.Add(entry, now), now)
+			if quiet != nil {
+				quiet.Reset(stopQuietPeriod)
+			}
+		case now := <-ticker.C:
+			handle(partials.Expired(now), now)
+			for _, rec := range lines.Expired(now) {
+				push(rec)
 			}
 		}
-		// TODO: include-env (Docker does not pass env by default to logging drivers)
+	}
+}
 
-		// Warn if unsupported per-container transport overrides are set
-		if _, ok := info.Config["endpoint"]; ok {
-			fmt.Fprintln(os.Stderr, "per-container endpoint override not yet supported; using plugin-level endpoint")
+// decodeEntries reads length-delimited entries from the FIFO until it is closed,
+// calling decodeErr for every frame it has to skip.
+func decodeEntries(ctx context.Context, r io.Reader, out chan<- *logdriver.LogEntry, decodeErr func()) {
+	defer close(out)
+	dec := protoio.NewUint32DelimitedReader(r, binary.BigEndian, 1e6)
+	defer func() { _ = dec.Close() }()
+	for {
+		entry := &logdriver.LogEntry{}
+		if err := dec.ReadMsg(entry); err != nil {
+			if err == io.EOF || err == io.ErrClosedPipe || ctx.Err() != nil {
+				return
+			}
+			// Recreate reader on transient error.
+			decodeErr()
+			dec = protoio.NewUint32DelimitedReader(r, binary.BigEndian, 1e6)
+			continue
 		}
-		if _, ok := info.Config["headers"]; ok {
-			fmt.Fprintln(os.Stderr, "per-container headers override not yet supported; using plugin-level headers")
+		select {
+		case out <- entry:
+		case <-ctx.Done():
+			return
 		}
+	}
+}
+
+// store writes an entry, as received from Docker, to the container's local log store,
+// if any.
+func (d *Driver) store(in *dockerInput, entry *logdriver.LogEntry) {
+	if in.store == nil {
+		return
+	}
+	if err := in.store.Write(entry); err != nil {
+		in.log.Error("cannot write to local log store", "error", err)
+	}
+}
 
-		rec := otelx.BuildRecord(time.Unix(0, entry.TimeNano), string(entry.Line), severity, attrs...)
-		otelLogger.Emit(context.Background(), rec)
-		entry.Reset()
+// emit forwards a complete record as an OTEL log record.
+func (d *Driver) emit(otelLogger olog.Logger, in *dockerInput, entry *logdriver.LogEntry) {
+	rec, sc := buildRecord(in, entry)
+	ctx := context.Background()
+	if sc.HasTraceID() || sc.HasSpanID() {
+		// The SDK takes a record's trace context from the context it is emitted with.
+		ctx = trace.ContextWithSpanContext(ctx, sc)
 	}
+	otelLogger.Emit(ctx, rec)
 }
@@ -1,10 +1,17 @@
 package driver
 
 import (
+	"bytes"
 	"context"
 	"encoding/binary"
+	"errors"
 	"io"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
@@ -13,10 +20,12 @@ import (
 	protoio "github.com/gogo/protobuf/io"
 
 	"github.com/moritzloewenstein/otel-docker-logging-driver/internal/config"
+	"github.com/moritzloewenstein/otel-docker-logging-driver/internal/otelx"
 
 	olog "go.opentelemetry.io/otel/log"
-	"go.opentelemetry.io/otel/log/global"
 	logsdk "go.opentelemetry.io/otel/sdk/log"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
 )
 
 // captureExporter implements a logsdk.Exporter to capture records synchronously.
@@ -35,11 +44,8 @@ func (e *captureExporter) Shutdown(context.Context) error   { return nil }
 func (e *captureExporter) ForceFlush(context.Context) error { return nil }
 
 func TestConsume_MappingAndLabels(t *testing.T) {
-	// Install a capturing logger provider.
+	// Install a capturing exporter.
 	exp := &captureExporter{}
-	proc := logsdk.NewSimpleProcessor(exp)
-	provider := logsdk.NewLoggerProvider(logsdk.WithProcessor(proc))
-	global.SetLoggerProvider(provider)
 
 	// Prepare a pipe with two docker log entries: stdout and stderr.
 	pr, pw := io.Pipe()
@@ -53,10 +59,10 @@ func TestConsume_MappingAndLabels(t *testing.T) {
 		ContainerLabels:    map[string]string{"test.label": "demo"},
 	}
 
-	d := New(config.Config{}, nil)
+	d := newTestDriver(exp)
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
-	go d.consume(ctx, pr, info)
+	go d.consume(ctx, newTestInput(t, d, pr, info))
 
 	w := protoio.NewUint32DelimitedWriter(pw, binary.BigEndian)
 	write := func(src, body string, ts int64) {
@@ -116,12 +122,375 @@ func TestConsume_MappingAndLabels(t *testing.T) {
 		t.Fatalf("label missing: %v", attrs)
 	}
 
+	// Records carry the container's resource rather than the driver's
+	res := map[string]string{}
+	for _, kv := range recs[0].Resource().Attributes() {
+		res[string(kv.Key)] = kv.Value.Emit()
+	}
+	if res["service.name"] != "demo" || res["container.id"] != "cid123" {
+		t.Fatalf("resource=%v", res)
+	}
+
 	// Second record should map stderr to error severity
 	if reccSev(recs[1]) != olog.SeverityError {
 		t.Fatalf("sev1=%v", reccSev(recs[1]))
 	}
 }
 
+func TestConsume_JSONBody(t *testing.T) {
+	info := logger.Info{
+		ContainerID: "cid123",
+		Config:      map[string]string{"parse": "json", "parse-attributes": "user,missing"},
+	}
+	recs := consumeLines(t, info, `{"msg":"hi","user":"bob","nested":{"n":1}}`, "not json")
+	if len(recs) != 2 {
+		t.Fatalf("records=%d", len(recs))
+	}
+
+	body := recs[0].Body()
+	if body.Kind() != olog.KindMap {
+		t.Fatalf("body kind=%v", body.Kind())
+	}
+	keys := map[string]olog.Value{}
+	for _, kv := range body.AsMap() {
+		keys[kv.Key] = kv.Value
+	}
+	if keys["msg"].AsString() != "hi" || keys["nested"].Kind() != olog.KindMap {
+		t.Fatalf("body=%v", keys)
+	}
+	if _, ok := keys["user"]; ok {
+		t.Fatalf("lifted key still in body: %v", keys)
+	}
+	if attrs := recAttrs(recs[0]); attrs["user"] != "bob" {
+		t.Fatalf("lifted attr missing: %v", attrs)
+	}
+
+	if got := recs[1].Body(); got.Kind() != olog.KindString || got.AsString() != "not json" {
+		t.Fatalf("fallback body=%v", got)
+	}
+}
+
+func TestConsume_TraceContext(t *testing.T) {
+	info := logger.Info{ContainerID: "cid123", Config: map[string]string{"parse": "json"}}
+	recs := consumeLines(t, info,
+		`{"msg":"hi","trace_id":"4bf92f3577b34da6a3ce929d0e0e4736","span_id":"00f067aa0ba902b7","trace_flags":"01"}`,
+		`GET / traceparent=00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00`,
+		`{"msg":"untraced"}`)
+	if len(recs) != 3 {
+		t.Fatalf("records=%d", len(recs))
+	}
+	for i, rec := range recs[:2] {
+		if rec.TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" || rec.SpanID().String() != "00f067aa0ba902b7" {
+			t.Fatalf("record %d: trace=%v span=%v", i, rec.TraceID(), rec.SpanID())
+		}
+	}
+	if !recs[0].TraceFlags().IsSampled() || recs[1].TraceFlags().IsSampled() {
+		t.Fatalf("flags=%v %v", recs[0].TraceFlags(), recs[1].TraceFlags())
+	}
+	if recs[2].TraceID().IsValid() || recs[2].SpanID().IsValid() {
+		t.Fatalf("untraced record: trace=%v span=%v", recs[2].TraceID(), recs[2].SpanID())
+	}
+}
+
+// consumeLines runs consume over the given stdout lines and returns the emitted records.
+func consumeLines(t *testing.T, info logger.Info, lines ...string) []logsdk.Record {
+	t.Helper()
+	entries := make([]*logdriver.LogEntry, len(lines))
+	for i, l := range lines {
+		entries[i] = &logdriver.LogEntry{Source: "stdout", Line: []byte(l), TimeNano: time.Now().UnixNano()}
+	}
+	return consumeEntries(t, info, entries...)
+}
+
+// consumeEntries feeds entries through consume until the FIFO is drained and returns
+// the emitted records.
+func consumeEntries(t *testing.T, info logger.Info, entries ...*logdriver.LogEntry) []logsdk.Record {
+	t.Helper()
+	exp := &captureExporter{}
+	d := newTestDriver(exp)
+
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		d.consume(context.Background(), newTestInput(t, d, pr, info))
+		close(done)
+	}()
+
+	w := protoio.NewUint32DelimitedWriter(pw, binary.BigEndian)
+	for _, e := range entries {
+		if err := w.WriteMsg(e); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	_ = pw.Close()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("consume did not finish")
+	}
+	exp.mu.Lock()
+	defer exp.mu.Unlock()
+	return append([]logsdk.Record(nil), exp.recs...)
+}
+
+func TestConsume_Metrics(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	metrics, err := otelx.NewMetrics(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)))
+	if err != nil {
+		t.Fatalf("metrics: %v", err)
+	}
+	d := newTestDriver(&captureExporter{})
+	d.metrics = metrics
+
+	info := logger.Info{ContainerID: "cid", ContainerName: "/web", Config: map[string]string{}}
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		d.consume(context.Background(), newTestInput(t, d, pr, info))
+		close(done)
+	}()
+	w := protoio.NewUint32DelimitedWriter(pw, binary.BigEndian)
+	for _, l := range []string{"a", "b", "c"} {
+		_ = w.WriteMsg(&logdriver.LogEntry{Source: "stdout", Line: []byte(l)})
+	}
+	_ = pw.Close()
+	<-done
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatalf("collect: %v", err)
+	}
+	sums := map[string]int64{}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if data, ok := m.Data.(metricdata.Sum[int64]); ok {
+				for _, dp := range data.DataPoints {
+					if v, _ := dp.Attributes.Value("container.name"); v.AsString() == "web" {
+						sums[m.Name] += dp.Value
+					}
+				}
+			}
+		}
+	}
+	if sums["logdriver.records.received"] != 3 || sums["logdriver.records.emitted"] != 3 || sums["logdriver.containers.active"] != 0 {
+		t.Fatalf("sums=%v", sums)
+	}
+}
+
+func TestStatus_ReportsConfigProblems(t *testing.T) {
+	if st := New(config.Config{Endpoint: "collector:4317"}, nil, nil).Status(); st.Degraded || len(st.Problems) != 0 {
+		t.Fatalf("status=%+v", st)
+	}
+	st := New(config.Config{Endpoint: "collector:4317", Compression: "zstd"}, nil, nil).Status()
+	if !st.Degraded || len(st.Problems) != 1 || st.Problems[0].Env != "OTEL_EXPORTER_OTLP_LOGS_COMPRESSION" {
+		t.Fatalf("status=%+v", st)
+	}
+}
+
+func TestStatus_ListsContainers(t *testing.T) {
+	d := newTestDriver(&captureExporter{})
+	info := logger.Info{
+		ContainerID:   "cid",
+		ContainerName: "/web",
+		Config:        map[string]string{"buffer-size": "10", "headers": "authorization=secret"},
+	}
+	pr, pw := io.Pipe()
+	in := newTestInput(t, d, pr, info)
+	d.mu.Lock()
+	d.logs["/run/docker/logging/fifo"] = in
+	d.mu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		d.consume(context.Background(), in)
+		close(done)
+	}()
+
+	w := protoio.NewUint32DelimitedWriter(pw, binary.BigEndian)
+	ts := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	_ = w.WriteMsg(&logdriver.LogEntry{Source: "stdout", Line: []byte("a"), TimeNano: ts.UnixNano()})
+	_ = w.WriteMsg(&logdriver.LogEntry{Source: "stdout", Line: []byte("b"), TimeNano: ts.UnixNano()})
+	_ = pw.Close()
+	<-done
+
+	st := d.Status()
+	if len(st.Containers) != 1 {
+		t.Fatalf("containers=%+v", st.Containers)
+	}
+	c := st.Containers[0]
+	if c.File != "/run/docker/logging/fifo" || c.ContainerID != "cid" || c.ContainerName != "web" {
+		t.Fatalf("identity=%+v", c)
+	}
+	if c.Received != 2 || c.Emitted != 2 || c.Dropped != 0 || !c.LastLine.Equal(ts) || c.Started.IsZero() {
+		t.Fatalf("counters=%+v", c)
+	}
+	if c.Options["buffer-size"] != "10" || c.Options["buffer-policy"] != "block" || c.Options["headers"] != "" {
+		t.Fatalf("options=%v", c.Options)
+	}
+}
+
+func TestWarnings(t *testing.T) {
+	d := newTestDriver(&captureExporter{})
+	d.cfg = config.Config{Endpoint: "collector:4317", Headers: map[string]string{"authorization": "secret"}}
+
+	in := newTestInput(t, d, nil, logger.Info{ContainerID: "cid", Config: map[string]string{}})
+	if w := d.warnings(in); len(w) != 0 {
+		t.Fatalf("warnings=%v", w)
+	}
+	in = newTestInput(t, d, nil, logger.Info{ContainerID: "cid", Config: map[string]string{
+		"endpoint":         "other:4317",
+		"parse-attributes": "user",
+		"timestamp-field":  "ts",
+	}})
+	if w := d.warnings(in); len(w) != 3 {
+		t.Fatalf("warnings=%v", w)
+	}
+	in = newTestInput(t, d, nil, logger.Info{ContainerID: "cid", Config: map[string]string{
+		"endpoint":         "other:4317",
+		"headers":          "authorization=other",
+		"parse":            "json",
+		"parse-attributes": "user",
+		"timestamp-field":  "ts",
+	}})
+	if w := d.warnings(in); len(w) != 0 {
+		t.Fatalf("warnings=%v", w)
+	}
+}
+
+func TestNewInput_DegradedWithoutExporter(t *testing.T) {
+	pool := otelx.NewPool(
+		func(context.Context, config.Config) (otelx.Exporter, error) {
+			return nil, errors.New("cannot read CA certificate")
+		},
+		func(_ config.Config, e otelx.Exporter) logsdk.Processor { return logsdk.NewSimpleProcessor(e) },
+	)
+	info := logger.Info{ContainerID: "cid", Config: map[string]string{}}
+	if _, err := New(config.Config{}, pool, nil).newInput(info); err == nil {
+		t.Fatalf("container accepted without exporter")
+	}
+
+	dir := t.TempDir()
+	d := New(config.Config{Degraded: true, LogDir: dir}, pool, nil)
+	pr, pw := io.Pipe()
+	in := newTestInput(t, d, pr, info)
+	go d.consume(context.Background(), in)
+	_ = protoio.NewUint32DelimitedWriter(pw, binary.BigEndian).WriteMsg(&logdriver.LogEntry{Source: "stdout", Line: []byte("kept locally")})
+	_ = pw.Close()
+	<-in.done
+
+	if st := in.status("fifo", d.metrics); !strings.Contains(st.ExporterError, "cannot read CA certificate") || st.Emitted != 0 || st.Received != 1 {
+		t.Fatalf("status=%+v", st)
+	}
+	var buf bytes.Buffer
+	if err := readLogs(context.Background(), dir, "cid", logger.ReadConfig{Tail: -1}, &buf, func() bool { return false }); err != nil || !bytes.Contains(buf.Bytes(), []byte("kept locally")) {
+		t.Fatalf("local store: %v %q", err, buf.String())
+	}
+}
+
+func TestStopLogging_DrainsAndFlushes(t *testing.T) {
+	exp := &captureExporter{}
+	d := newBatchingTestDriver(exp)
+	w := startTestInput(t, d, "fifo", "cid")
+	writeLines(w, "a", "b", "c")
+
+	start := time.Now()
+	if err := d.StopLogging("fifo"); err != nil {
+		t.Fatalf("stop: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("StopLogging took %s", elapsed)
+	}
+	exp.mu.Lock()
+	n := len(exp.recs)
+	exp.mu.Unlock()
+	if n != 3 {
+		t.Fatalf("exported %d records before StopLogging returned", n)
+	}
+	if d.isLogging("cid") {
+		t.Fatalf("container still tracked")
+	}
+}
+
+func TestShutdown_DrainsEveryContainer(t *testing.T) {
+	exp := &captureExporter{}
+	d := newBatchingTestDriver(exp)
+	writeLines(startTestInput(t, d, "fifo1", "cid1"), "a", "b")
+	writeLines(startTestInput(t, d, "fifo2", "cid2"), "c")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if n, err := d.Shutdown(ctx); n != 0 || err != nil {
+		t.Fatalf("shutdown: abandoned=%d err=%v", n, err)
+	}
+	exp.mu.Lock()
+	n := len(exp.recs)
+	exp.mu.Unlock()
+	if n != 3 || d.isLogging("cid1") || d.isLogging("cid2") {
+		t.Fatalf("exported=%d", n)
+	}
+	if err := d.StartLogging("fifo3", logger.Info{ContainerID: "cid3"}); err != errShuttingDown {
+		t.Fatalf("start after shutdown: %v", err)
+	}
+}
+
+// newBatchingTestDriver returns a driver whose records wait in a batch processor
+// until flushed.
+func newBatchingTestDriver(exp logsdk.Exporter) *Driver {
+	pool := otelx.NewPool(
+		func(context.Context, config.Config) (otelx.Exporter, error) { return exp, nil },
+		func(_ config.Config, e otelx.Exporter) logsdk.Processor {
+			return logsdk.NewBatchProcessor(e, logsdk.WithExportInterval(time.Hour))
+		},
+	)
+	return New(config.Config{StopTimeout: 2 * time.Second}, pool, nil)
+}
+
+// startTestInput tracks and consumes a FIFO as StartLogging does and returns its
+// write end, which, as with Docker, stays open until the test ends.
+func startTestInput(t *testing.T, d *Driver, file, containerID string) *io.PipeWriter {
+	t.Helper()
+	pr, pw := io.Pipe()
+	t.Cleanup(func() { _ = pw.Close() })
+	in := newTestInput(t, d, pr, logger.Info{ContainerID: containerID, Config: map[string]string{}})
+	ctx, cancel := context.WithCancel(context.Background())
+	in.cancel = cancel
+	d.mu.Lock()
+	d.logs[file] = in
+	d.mu.Unlock()
+	go d.consume(ctx, in)
+	return pw
+}
+
+// writeLines writes stdout entries to w from a goroutine, as reading them may block.
+func writeLines(w io.Writer, lines ...string) {
+	dw := protoio.NewUint32DelimitedWriter(w, binary.BigEndian)
+	go func() {
+		for _, l := range lines {
+			_ = dw.WriteMsg(&logdriver.LogEntry{Source: "stdout", Line: []byte(l)})
+		}
+	}()
+}
+
+// newTestDriver returns a driver whose exporters all write synchronously to exp.
+func newTestDriver(exp logsdk.Exporter) *Driver {
+	pool := otelx.NewPool(
+		func(context.Context, config.Config) (otelx.Exporter, error) { return exp, nil },
+		func(_ config.Config, e otelx.Exporter) logsdk.Processor { return logsdk.NewSimpleProcessor(e) },
+	)
+	return New(config.Config{}, pool, nil)
+}
+
+// newTestInput wires a reader into a dockerInput resolved by d from info.
+func newTestInput(t *testing.T, d *Driver, r io.ReadCloser, info logger.Info) *dockerInput {
+	t.Helper()
+	in, err := d.newInput(info)
+	if err != nil {
+		t.Fatalf("newInput: %v", err)
+	}
+	in.stream = r
+	return in
+}
+
 // helpers to read values from sdk/log.Record
 
 func reccStr(v olog.Value) string {
@@ -140,3 +509,163 @@ func recAttrs(r logsdk.Record) map[string]string {
 	})
 	return m
 }
+
+func TestStartLogging_ShutdownDuringSetup(t *testing.T) {
+	acquiring, resume := make(chan struct{}), make(chan struct{})
+	pool := otelx.NewPool(
+		func(context.Context, config.Config) (otelx.Exporter, error) {
+			close(acquiring)
+			<-resume
+			return &captureExporter{}, nil
+		},
+		func(_ config.Config, e otelx.Exporter) logsdk.Processor { return logsdk.NewSimpleProcessor(e) },
+	)
+	d := New(config.Config{}, pool, nil)
+	file := filepath.Join(t.TempDir(), "fifo")
+	if err := syscall.Mkfifo(file, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// Docker holds the writing end open, which opening the FIFO waits for.
+	writer := make(chan *os.File, 1)
+	go func() {
+		w, _ := os.OpenFile(file, os.O_WRONLY, 0)
+		writer <- w
+	}()
+	defer func() {
+		if w := <-writer; w != nil {
+			_ = w.Close()
+		}
+	}()
+
+	errc := make(chan error, 1)
+	go func() { errc <- d.StartLogging(file, logger.Info{ContainerID: "cid", Config: map[string]string{}}) }()
+	<-acquiring
+	if _, err := d.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	close(resume)
+
+	if err := <-errc; err != errShuttingDown {
+		t.Fatalf("start during shutdown: %v", err)
+	}
+	if d.isLogging("cid") || pool.Len() != 0 {
+		t.Fatalf("input left behind: logging=%v exporters=%d", d.isLogging("cid"), pool.Len())
+	}
+}
+
+func TestConsume_StoresPartialFragments(t *testing.T) {
+	exp := &captureExporter{}
+	dir := t.TempDir()
+	pool := otelx.NewPool(
+		func(context.Context, config.Config) (otelx.Exporter, error) { return exp, nil },
+		func(_ config.Config, e otelx.Exporter) logsdk.Processor { return logsdk.NewSimpleProcessor(e) },
+	)
+	d := New(config.Config{LogDir: dir}, pool, nil)
+	info := logger.Info{ContainerID: "cid", Config: map[string]string{"partial-max-size": "2m"}}
+	pr, pw := io.Pipe()
+	in := newTestInput(t, d, pr, info)
+	go d.consume(context.Background(), in)
+
+	// A line of 1.1 MB in 16 KiB fragments, as Docker splits it.
+	const fragment, fragments = 16 * 1024, 70
+	w := protoio.NewUint32DelimitedWriter(pw, binary.BigEndian)
+	go func() {
+		for i := 0; i < fragments; i++ {
+			_ = w.WriteMsg(&logdriver.LogEntry{
+				Source: "stdout", Line: bytes.Repeat([]byte{'a' + byte(i%26)}, fragment), TimeNano: time.Now().UnixNano(), Partial: i < fragments-1,
+				PartialLogMetadata: &logdriver.PartialLogEntryMetadata{Id: "p1", Ordinal: int32(i + 1), Last: i == fragments-1},
+			})
+		}
+		_ = pw.Close()
+	}()
+	<-in.done
+
+	exp.mu.Lock()
+	n := len(exp.recs)
+	exp.mu.Unlock()
+	if n != 1 {
+		t.Fatalf("exported %d records", n)
+	}
+	var frames []*logdriver.LogEntry
+	var line []byte
+	err := readEntries(context.Background(), dir, "cid", logger.ReadConfig{Tail: -1}, func(e *logdriver.LogEntry) {
+		frames = append(frames, e)
+		line = append(line, e.Line...)
+	})
+	if err != nil || len(frames) != fragments || len(line) != fragment*fragments || !frames[fragments-1].PartialLogMetadata.Last {
+		t.Fatalf("read back %d frames, %d bytes: %v", len(frames), len(line), err)
+	}
+}
+
+// readEntries decodes what readLogs writes for a container.
+func readEntries(ctx context.Context, dir, containerID string, cfg logger.ReadConfig, fn func(*logdriver.LogEntry)) error {
+	var buf bytes.Buffer
+	if err := readLogs(ctx, dir, containerID, cfg, &buf, func() bool { return false }); err != nil {
+		return err
+	}
+	dec := logdriver.NewLogEntryDecoder(&buf)
+	for {
+		var e logdriver.LogEntry
+		if err := dec.Decode(&e); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		fn(&e)
+	}
+}
+
+// stalledExporter blocks exports until released, then fails them like an
+// unreachable collector.
+type stalledExporter struct {
+	captureExporter
+	calls   atomic.Int32
+	release chan struct{}
+}
+
+func (e *stalledExporter) Export(context.Context, []logsdk.Record) error {
+	e.calls.Add(1)
+	<-e.release
+	return errors.New("connection refused")
+}
+
+func TestShutdown_CountsAbandonedRecords(t *testing.T) {
+	exp := &stalledExporter{release: make(chan struct{})}
+	pool := otelx.NewPool(
+		func(context.Context, config.Config) (otelx.Exporter, error) { return exp, nil },
+		func(_ config.Config, e otelx.Exporter) logsdk.Processor { return logsdk.NewSimpleProcessor(e) },
+	)
+	d := New(config.Config{}, pool, nil)
+	writeLines(startTestInput(t, d, "fifo", "cid"), "a", "b", "c", "d", "e")
+	// The first record is stuck in the exporter, the others wait in the buffer.
+	deadline := time.Now().Add(2 * time.Second)
+	for exp.calls.Load() == 0 || d.bufferLen("fifo") != 4 {
+		if time.Now().After(deadline) {
+			t.Fatalf("records not buffered: calls=%d buffered=%d", exp.calls.Load(), d.bufferLen("fifo"))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	time.AfterFunc(300*time.Millisecond, func() { close(exp.release) })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	abandoned, err := d.Shutdown(ctx)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("shutdown: %v", err)
+	}
+	_ = pool.Shutdown(context.Background())
+	// Four records never reached the exporter, the fifth was not exported.
+	if abandoned != 4 || pool.Pending() != 1 {
+		t.Fatalf("abandoned=%d pending=%d", abandoned, pool.Pending())
+	}
+}
+
+func (d *Driver) bufferLen(file string) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if in, ok := d.logs[file]; ok {
+		return in.buffer.Len()
+	}
+	return -1
+}
@@ -0,0 +1,405 @@
+package driver
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/plugins/logdriver"
+	"github.com/docker/docker/daemon/logger"
+)
+
+const (
+	defaultLocalMaxSize  = 20 * 1024 * 1024
+	defaultLocalMaxFiles = 5
+
+	// maxFrameSize bounds a single stored entry, matching the decoder limit used for the FIFO.
+	maxFrameSize = 1e6
+
+	followPollInterval = 250 * time.Millisecond
+
+	// How often LOG_DIR is checked for store files to remove
+	pruneInterval = 10 * time.Minute
+)
+
+// logStore keeps a local copy of a container's entries so `docker logs` can be served
+// through ReadLogs. Entries are stored in the same length-delimited protobuf framing
+// Docker uses on the FIFO, in <dir>/<container-id>.log with numbered rotations.
+type logStore struct {
+	mu       sync.Mutex
+	path     string
+	f        *os.File
+	enc      logdriver.LogEntryEncoder
+	size     int64
+	maxSize  int64
+	maxFiles int
+	// Called on the first Close; set by logDir
+	release func()
+}
+
+func storePath(dir, containerID string) string {
+	return filepath.Join(dir, containerID+".log")
+}
+
+func openLogStore(dir, containerID string, maxSize int64, maxFiles int) (*logStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("create log dir %q: %w", dir, err)
+	}
+	s := &logStore{path: storePath(dir, containerID), maxSize: maxSize, maxFiles: maxFiles}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *logStore) open() error {
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("open log store %q: %w", s.path, err)
+	}
+	st, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return fmt.Errorf("stat log store %q: %w", s.path, err)
+	}
+	s.f = f
+	s.enc = logdriver.NewLogEntryEncoder(f)
+	s.size = st.Size()
+	return nil
+}
+
+// Write appends an entry, rotating the file first when it would exceed maxSize.
+func (s *logStore) Write(entry *logdriver.LogEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return os.ErrClosed
+	}
+	n := int64(entry.Size() + 4)
+	if s.maxSize > 0 && s.size > 0 && s.size+n > s.maxSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	if err := s.enc.Encode(entry); err != nil {
+		return err
+	}
+	s.size += n
+	return nil
+}
+
+func (s *logStore) rotate() error {
+	if err := s.f.Close(); err != nil {
+		return err
+	}
+	s.f = nil
+	if s.maxFiles > 1 {
+		for i := s.maxFiles - 1; i > 0; i-- {
+			from := s.path
+			if i > 1 {
+				from += "." + strconv.Itoa(i-1)
+			}
+			if err := os.Rename(from, s.path+"."+strconv.Itoa(i)); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("rotate log store: %w", err)
+			}
+		}
+	} else if err := os.Remove(s.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("rotate log store: %w", err)
+	}
+	return s.open()
+}
+
+func (s *logStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.release != nil {
+		s.release()
+		s.release = nil
+	}
+	if s.f == nil {
+		return nil
+	}
+	err := s.f.Close()
+	s.f = nil
+	return err
+}
+
+// logDir is the directory holding the stores of all containers. It keeps track of the
+// stores that are open so pruning never removes the files of a container that logs.
+type logDir struct {
+	mu   sync.Mutex
+	path string
+	// Open stores by container ID
+	open map[string]int
+}
+
+func newLogDir(path string) *logDir {
+	return &logDir{path: path, open: map[string]int{}}
+}
+
+// openStore opens the store of a container; it is protected from pruning until closed.
+func (l *logDir) openStore(containerID string, maxSize int64, maxFiles int) (*logStore, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	s, err := openLogStore(l.path, containerID, maxSize, maxFiles)
+	if err != nil {
+		return nil, err
+	}
+	l.open[containerID]++
+	s.release = func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if l.open[containerID]--; l.open[containerID] <= 0 {
+			delete(l.open, containerID)
+		}
+	}
+	return s, nil
+}
+
+// storeContainerID returns the container ID of a store file name, <id>.log or a
+// rotation <id>.log.<n>, or "" for any other file.
+func storeContainerID(name string) string {
+	id, suffix, ok := strings.Cut(name, ".log")
+	if !ok || id == "" {
+		return ""
+	}
+	if suffix != "" {
+		if n, err := strconv.Atoi(strings.TrimPrefix(suffix, ".")); err != nil || n <= 0 || suffix[0] != '.' {
+			return ""
+		}
+	}
+	return id
+}
+
+// prune removes the store files of containers that are not logging: first those not
+// written to for longer than maxAge, then, least recently written first, as many as
+// needed to bring the directory down to maxSize bytes. A limit of 0 is not applied.
+// Stores of containers that are logging count towards maxSize but are never removed.
+// It returns the IDs of the containers whose files were removed.
+func (l *logDir) prune(maxAge time.Duration, maxSize int64, now time.Time) ([]string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entries, err := os.ReadDir(l.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	type container struct {
+		id       string
+		files    []string
+		size     int64
+		modified time.Time
+	}
+	byID := map[string]*container{}
+	var total int64
+	for _, e := range entries {
+		id := storeContainerID(e.Name())
+		if id == "" || !e.Type().IsRegular() {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		c := byID[id]
+		if c == nil {
+			c = &container{id: id}
+			byID[id] = c
+		}
+		c.files = append(c.files, filepath.Join(l.path, e.Name()))
+		c.size += info.Size()
+		total += info.Size()
+		if info.ModTime().After(c.modified) {
+			c.modified = info.ModTime()
+		}
+	}
+	var inactive []*container
+	for id, c := range byID {
+		if l.open[id] == 0 {
+			inactive = append(inactive, c)
+		}
+	}
+	sort.Slice(inactive, func(i, j int) bool { return inactive[i].modified.Before(inactive[j].modified) })
+
+	var removed []string
+	var errs []error
+	for _, c := range inactive {
+		expired := maxAge > 0 && now.Sub(c.modified) > maxAge
+		if !expired && (maxSize <= 0 || total <= maxSize) {
+			continue
+		}
+		for _, f := range c.files {
+			if err := os.Remove(f); err != nil && !errors.Is(err, os.ErrNotExist) {
+				errs = append(errs, err)
+			}
+		}
+		total -= c.size
+		removed = append(removed, c.id)
+	}
+	return removed, errors.Join(errs...)
+}
+
+// readLogs writes the stored entries of a container to w, honouring Since, Until and
+// Tail. With Follow it keeps polling for new entries until ctx is cancelled, Until is
+// passed, or active reports that the container no longer logs and the file is drained.
+func readLogs(ctx context.Context, dir, containerID string, cfg logger.ReadConfig, w io.Writer, active func() bool) error {
+	enc := logdriver.NewLogEntryEncoder(w)
+	path := storePath(dir, containerID)
+
+	var tail []*logdriver.LogEntry
+	emit := func(e *logdriver.LogEntry) (bool, error) {
+		ts := time.Unix(0, e.TimeNano)
+		if !cfg.Since.IsZero() && ts.Before(cfg.Since) {
+			return true, nil
+		}
+		if !cfg.Until.IsZero() && ts.After(cfg.Until) {
+			return false, nil
+		}
+		if cfg.Tail >= 0 {
+			if cfg.Tail == 0 {
+				return true, nil
+			}
+			tail = append(tail, e)
+			if len(tail) > cfg.Tail {
+				tail = tail[1:]
+			}
+			return true, nil
+		}
+		return true, enc.Encode(e)
+	}
+
+	// Rotated files first, oldest to newest, then the live file which we keep open for Follow.
+	var rotated []string
+	for i := 1; ; i++ {
+		p := path + "." + strconv.Itoa(i)
+		if _, err := os.Stat(p); err != nil {
+			break
+		}
+		rotated = append([]string{p}, rotated...)
+	}
+	for _, p := range rotated {
+		f, err := os.Open(p)
+		if err != nil {
+			continue
+		}
+		more, err := readFrames(f, emit)
+		_ = f.Close()
+		if err != nil {
+			return err
+		}
+		if !more {
+			return flushTail(enc, tail)
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return flushTail(enc, tail)
+		}
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	more, err := readFrames(f, emit)
+	if err != nil {
+		return err
+	}
+	if err := flushTail(enc, tail); err != nil || !more || !cfg.Follow {
+		return err
+	}
+
+	cfg.Tail = -1
+	tail = nil
+	ticker := time.NewTicker(followPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		running := active()
+		more, err := readFrames(f, emit)
+		if err != nil || !more {
+			return err
+		}
+		// The writer rotated the file: drain what is left in the old one, then switch over.
+		if st, err := os.Stat(path); err == nil {
+			if cur, err := f.Stat(); err == nil && !os.SameFile(st, cur) {
+				if more, err := readFrames(f, emit); err != nil || !more {
+					return err
+				}
+				nf, err := os.Open(path)
+				if err != nil {
+					return err
+				}
+				_ = f.Close()
+				f = nf
+				continue
+			}
+		}
+		if !running {
+			return nil
+		}
+	}
+}
+
+func flushTail(enc logdriver.LogEntryEncoder, tail []*logdriver.LogEntry) error {
+	for _, e := range tail {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readFrames decodes entries from f until EOF. An incomplete trailing frame (the writer
+// is mid-append) is left in place by seeking back to its start so a later call can
+// pick it up. It reports false when fn asked to stop.
+func readFrames(f *os.File, fn func(*logdriver.LogEntry) (bool, error)) (bool, error) {
+	var lenBuf [4]byte
+	for {
+		start, err := f.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return false, err
+		}
+		if _, err := io.ReadFull(f, lenBuf[:]); err != nil {
+			return true, rewind(f, start, err)
+		}
+		size := binary.BigEndian.Uint32(lenBuf[:])
+		if size > maxFrameSize {
+			return false, fmt.Errorf("corrupt log store %q: frame of %d bytes", f.Name(), size)
+		}
+		buf := make([]byte, size)
+		if _, err := io.ReadFull(f, buf); err != nil {
+			return true, rewind(f, start, err)
+		}
+		var e logdriver.LogEntry
+		if err := e.Unmarshal(buf); err != nil {
+			return false, fmt.Errorf("corrupt log store %q: %w", f.Name(), err)
+		}
+		more, err := fn(&e)
+		if err != nil || !more {
+			return false, err
+		}
+	}
+}
+
+func rewind(f *os.File, offset int64, readErr error) error {
+	if !errors.Is(readErr, io.EOF) && !errors.Is(readErr, io.ErrUnexpectedEOF) {
+		return readErr
+	}
+	_, err := f.Seek(offset, io.SeekStart)
+	return err
+}
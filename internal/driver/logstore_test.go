@@ -0,0 +1,201 @@
+package driver
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/plugins/logdriver"
+	"github.com/docker/docker/daemon/logger"
+)
+
+func decodeAll(t *testing.T, b []byte) []string {
+	t.Helper()
+	var lines []string
+	dec := logdriver.NewLogEntryDecoder(bytes.NewReader(b))
+	for {
+		var e logdriver.LogEntry
+		if err := dec.Decode(&e); err != nil {
+			return lines
+		}
+		lines = append(lines, string(e.Line))
+	}
+}
+
+func TestLogStore_ReadTailSinceAndRotation(t *testing.T) {
+	dir := t.TempDir()
+	// Small max size forces rotation across several files.
+	s, err := openLogStore(dir, "cid", 100, 3)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	base := time.Unix(1000, 0)
+	for i := 0; i < 20; i++ {
+		e := &logdriver.LogEntry{Source: "stdout", Line: []byte("line" + strconv.Itoa(i)), TimeNano: base.Add(time.Duration(i) * time.Second).UnixNano()}
+		if err := s.Write(e); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	_ = s.Close()
+
+	inactive := func() bool { return false }
+
+	var buf bytes.Buffer
+	if err := readLogs(context.Background(), dir, "cid", logger.ReadConfig{Tail: 2}, &buf, inactive); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if got := decodeAll(t, buf.Bytes()); len(got) != 2 || got[0] != "line18" || got[1] != "line19" {
+		t.Fatalf("tail=%v", got)
+	}
+
+	// Everything retained is returned oldest first; earlier files were rotated away.
+	buf.Reset()
+	if err := readLogs(context.Background(), dir, "cid", logger.ReadConfig{Tail: -1}, &buf, inactive); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	all := decodeAll(t, buf.Bytes())
+	if len(all) == 0 || len(all) == 20 || all[len(all)-1] != "line19" {
+		t.Fatalf("all=%v", all)
+	}
+	for i := 1; i < len(all); i++ {
+		a, _ := strconv.Atoi(all[i-1][4:])
+		b, _ := strconv.Atoi(all[i][4:])
+		if b != a+1 {
+			t.Fatalf("out of order: %v", all)
+		}
+	}
+
+	buf.Reset()
+	cfg := logger.ReadConfig{Tail: -1, Since: base.Add(17 * time.Second), Until: base.Add(18 * time.Second)}
+	if err := readLogs(context.Background(), dir, "cid", cfg, &buf, inactive); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if got := decodeAll(t, buf.Bytes()); len(got) != 2 || got[0] != "line17" || got[1] != "line18" {
+		t.Fatalf("since/until=%v", got)
+	}
+}
+
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]byte(nil), b.buf.Bytes()...)
+}
+
+func TestLogStore_Follow(t *testing.T) {
+	dir := t.TempDir()
+	s, err := openLogStore(dir, "cid", defaultLocalMaxSize, defaultLocalMaxFiles)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	since := time.Now()
+	_ = s.Write(&logdriver.LogEntry{Line: []byte("old"), TimeNano: since.Add(-time.Second).UnixNano()})
+
+	var mu sync.Mutex
+	running := true
+	active := func() bool { mu.Lock(); defer mu.Unlock(); return running }
+
+	out := &syncBuffer{}
+	done := make(chan error, 1)
+	go func() {
+		done <- readLogs(context.Background(), dir, "cid", logger.ReadConfig{Tail: -1, Since: since, Follow: true}, out, active)
+	}()
+
+	_ = s.Write(&logdriver.LogEntry{Line: []byte("new"), TimeNano: time.Now().UnixNano()})
+	deadline := time.Now().Add(2 * time.Second)
+	for len(decodeAll(t, out.Bytes())) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for followed entry")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	_ = s.Close()
+	mu.Lock()
+	running = false
+	mu.Unlock()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("follow: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("follow did not stop after container stopped")
+	}
+	if got := decodeAll(t, out.Bytes()); len(got) != 1 || got[0] != "new" {
+		t.Fatalf("followed=%v", got)
+	}
+}
+
+func TestLogDir_Prune(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	write := func(name string, size int, age time.Duration) {
+		t.Helper()
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, make([]byte, size), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(p, now.Add(-age), now.Add(-age)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("old.log", 10, 10*24*time.Hour)
+	write("old.log.1", 10, 11*24*time.Hour)
+	write("big.log", 100, 3*time.Hour)
+	write("recent.log", 100, time.Hour)
+	write("unrelated.txt", 1000, 30*24*time.Hour)
+	write("x.log.bak", 10, 30*24*time.Hour)
+
+	l := newLogDir(dir)
+	// An open store is never removed, however old.
+	active, err := l.openStore("active", 1<<20, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := active.Write(&logdriver.LogEntry{Line: []byte("x"), TimeNano: now.UnixNano()}); err != nil {
+		t.Fatal(err)
+	}
+	_ = os.Chtimes(storePath(dir, "active"), now.Add(-30*24*time.Hour), now.Add(-30*24*time.Hour))
+
+	removed, err := l.prune(7*24*time.Hour, 150, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// "old" has expired; "big" logged least recently and is removed to get under 150 bytes.
+	if strings.Join(removed, ",") != "old,big" {
+		t.Fatalf("removed %v", removed)
+	}
+	for name, want := range map[string]bool{
+		"old.log": false, "old.log.1": false, "big.log": false, "recent.log": true,
+		"active.log": true, "unrelated.txt": true, "x.log.bak": true,
+	} {
+		if _, err := os.Stat(filepath.Join(dir, name)); (err == nil) != want {
+			t.Errorf("%s: exists %v, want %v", name, err == nil, want)
+		}
+	}
+
+	// Once closed, the store is pruned like any other.
+	if err := active.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if removed, _ := l.prune(7*24*time.Hour, 0, now); strings.Join(removed, ",") != "active" {
+		t.Fatalf("removed %v", removed)
+	}
+}
@@ -0,0 +1,118 @@
+package driver
+
+import (
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/docker/docker/api/types/plugins/logdriver"
+)
+
+const (
+	defaultMultilineMaxLines     = 500
+	defaultMultilineMaxBytes     = 1024 * 1024
+	defaultMultilineFlushTimeout = time.Second
+)
+
+// multilinePreset describes how to recognise the lines that continue a record.
+type multilinePreset struct {
+	start *regexp.Regexp
+	cont  *regexp.Regexp
+}
+
+var multilinePresets = map[string]multilinePreset{
+	// Indented "at ..." frames, "... N more" and "Caused by:" chains.
+	"java": {cont: regexp.MustCompile(`^(\s|Caused by:|Suppressed:)`)},
+	// Tracebacks, chained exception banners and the final "SomeError: message" line.
+	"python": {cont: regexp.MustCompile(`^(\s|Traceback \(most recent call last\):|During handling of the above exception|The above exception was the direct cause|[A-Za-z_][\w.]*(Error|Exception|Exit|Interrupt|Warning)(:|$))`)},
+	// Goroutine dumps following "panic:" or "fatal error:": headers, function lines,
+	// indented file:line frames, blank separators and the trailing exit status.
+	"go": {cont: regexp.MustCompile(`^(\s|$|goroutine \d+ \[|\[signal |created by |[\w./*()-]+\(.*\)$|exit status \d+)`)},
+}
+
+// multilineAggregator merges continuation lines into the record they belong to.
+// Streams are aggregated independently so stdout output does not split a stderr trace.
+type multilineAggregator struct {
+	start    *regexp.Regexp
+	cont     *regexp.Regexp
+	maxLines int
+	maxBytes int64
+	timeout  time.Duration
+	pending  map[string]*multilineBuffer
+}
+
+type multilineBuffer struct {
+	entry *logdriver.LogEntry
+	lines int
+	last  time.Time
+}
+
+func newMultilineAggregator(opts containerOptions) *multilineAggregator {
+	return &multilineAggregator{
+		start:    opts.multilineStart,
+		cont:     opts.multilineContinue,
+		maxLines: opts.multilineMaxLines,
+		maxBytes: opts.multilineMaxBytes,
+		timeout:  opts.multilineFlushTimeout,
+		pending:  map[string]*multilineBuffer{},
+	}
+}
+
+func (m *multilineAggregator) enabled() bool {
+	return m.start != nil || m.cont != nil
+}
+
+// isContinuation reports whether line belongs to the preceding record: it matches the
+// continuation pattern, or a start pattern is configured and it does not match it.
+func (m *multilineAggregator) isContinuation(line []byte) bool {
+	if m.cont != nil && m.cont.Match(line) {
+		return true
+	}
+	return m.start != nil && !m.start.Match(line)
+}
+
+// Add consumes one complete line and returns the records it completes.
+func (m *multilineAggregator) Add(e *logdriver.LogEntry, now time.Time) []*logdriver.LogEntry {
+	if !m.enabled() {
+		return []*logdriver.LogEntry{e}
+	}
+	var out []*logdriver.LogEntry
+	buf, ok := m.pending[e.Source]
+	if ok && m.isContinuation(e.Line) &&
+		(m.maxLines <= 0 || buf.lines < m.maxLines) &&
+		(m.maxBytes <= 0 || int64(len(buf.entry.Line)+1+len(e.Line)) <= m.maxBytes) {
+		buf.entry.Line = append(append(buf.entry.Line, '\n'), e.Line...)
+		buf.lines++
+		buf.last = now
+		return nil
+	}
+	if ok {
+		out = append(out, buf.entry)
+	}
+	first := *e
+	first.Line = append([]byte(nil), e.Line...)
+	m.pending[e.Source] = &multilineBuffer{entry: &first, lines: 1, last: now}
+	return out
+}
+
+// Expired returns the records that saw no continuation line within the timeout.
+func (m *multilineAggregator) Expired(now time.Time) []*logdriver.LogEntry {
+	return m.take(func(buf *multilineBuffer) bool { return now.Sub(buf.last) >= m.timeout })
+}
+
+// Flush returns every pending record, used when the FIFO is closed.
+func (m *multilineAggregator) Flush() []*logdriver.LogEntry {
+	return m.take(func(*multilineBuffer) bool { return true })
+}
+
+func (m *multilineAggregator) take(match func(*multilineBuffer) bool) []*logdriver.LogEntry {
+	var out []*logdriver.LogEntry
+	for src, buf := range m.pending {
+		if match(buf) {
+			out = append(out, buf.entry)
+			delete(m.pending, src)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].TimeNano < out[j].TimeNano })
+	return out
+}
@@ -0,0 +1,111 @@
+package driver
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/plugins/logdriver"
+)
+
+// aggregate runs lines through a multiline aggregator built from log-opts and returns
+// the resulting record bodies.
+func aggregate(t *testing.T, opts map[string]string, source string, lines ...string) []string {
+	t.Helper()
+	o, err := parseOptions(opts)
+	if err != nil {
+		t.Fatalf("parseOptions: %v", err)
+	}
+	m := newMultilineAggregator(o)
+	now := time.Now()
+	var out []string
+	for i, l := range lines {
+		e := &logdriver.LogEntry{Source: source, Line: []byte(l), TimeNano: int64(i)}
+		for _, r := range m.Add(e, now) {
+			out = append(out, string(r.Line))
+		}
+	}
+	for _, r := range m.Flush() {
+		out = append(out, string(r.Line))
+	}
+	return out
+}
+
+func TestMultiline_Presets(t *testing.T) {
+	java := []string{
+		"2024-01-01 ERROR request failed",
+		"java.lang.IllegalStateException: boom",
+		"\tat com.example.App.run(App.java:10)",
+		"\tat com.example.App.main(App.java:5)",
+		"Caused by: java.io.IOException: disk",
+		"\t... 2 more",
+		"2024-01-01 INFO next",
+	}
+	// The exception line itself is not indented and starts a new record.
+	got := aggregate(t, map[string]string{"multiline": "java"}, "stderr", java...)
+	if len(got) != 3 || !strings.HasPrefix(got[1], "java.lang.IllegalStateException") || !strings.HasSuffix(got[1], "... 2 more") || got[2] != "2024-01-01 INFO next" {
+		t.Fatalf("java=%q", got)
+	}
+
+	python := []string{
+		"ERROR:root:failed",
+		"Traceback (most recent call last):",
+		`  File "app.py", line 3, in <module>`,
+		"    main()",
+		"KeyError: 'x'",
+		"INFO:root:next",
+	}
+	got = aggregate(t, map[string]string{"multiline": "python"}, "stderr", python...)
+	if len(got) != 2 || strings.Count(got[0], "\n") != 4 || got[1] != "INFO:root:next" {
+		t.Fatalf("python=%q", got)
+	}
+
+	goPanic := []string{
+		"panic: runtime error: index out of range",
+		"",
+		"goroutine 1 [running]:",
+		"main.main()",
+		"\t/app/main.go:8 +0x1d",
+		"exit status 2",
+		"starting again",
+	}
+	got = aggregate(t, map[string]string{"multiline": "go"}, "stderr", goPanic...)
+	if len(got) != 2 || strings.Count(got[0], "\n") != 5 || got[1] != "starting again" {
+		t.Fatalf("go=%q", got)
+	}
+}
+
+func TestMultiline_StartPatternAndLimits(t *testing.T) {
+	opts := map[string]string{"multiline-start": `^\d{4}-`, "multiline-max-lines": "3"}
+	got := aggregate(t, opts, "stdout", "2024-a", "x", "y", "z", "2024-b", "w")
+	if len(got) != 3 || got[0] != "2024-a\nx\ny" || got[1] != "z" || got[2] != "2024-b\nw" {
+		t.Fatalf("got=%q", got)
+	}
+
+	// Disabled by default: lines pass through untouched.
+	if got := aggregate(t, nil, "stdout", "a", " b"); len(got) != 2 {
+		t.Fatalf("passthrough=%q", got)
+	}
+
+	if _, err := parseOptions(map[string]string{"multiline-start": "("}); err == nil {
+		t.Fatalf("invalid regexp accepted")
+	}
+}
+
+func TestMultiline_TimeoutAndStreams(t *testing.T) {
+	o, _ := parseOptions(map[string]string{"multiline": "java", "multiline-flush-timeout": "100ms"})
+	m := newMultilineAggregator(o)
+	now := time.Now()
+	m.Add(&logdriver.LogEntry{Source: "stderr", Line: []byte("Exception")}, now)
+	// A stdout line in between does not end the stderr record.
+	if out := m.Add(&logdriver.LogEntry{Source: "stdout", Line: []byte("request ok")}, now); len(out) != 0 {
+		t.Fatalf("stdout ended stderr record: %v", out)
+	}
+	m.Add(&logdriver.LogEntry{Source: "stderr", Line: []byte("\tat x")}, now.Add(50*time.Millisecond))
+	if out := m.Expired(now.Add(100 * time.Millisecond)); len(out) != 1 || out[0].Source != "stdout" {
+		t.Fatalf("expired=%v", out)
+	}
+	if out := m.Expired(now.Add(150 * time.Millisecond)); len(out) != 1 || string(out[0].Line) != "Exception\n\tat x" {
+		t.Fatalf("expired=%v", out)
+	}
+}
@@ -0,0 +1,305 @@
+package driver
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/go-units"
+
+	"github.com/moritzloewenstein/otel-docker-logging-driver/internal/otelx"
+)
+
+// containerOptions holds the per-container settings parsed from --log-opt.
+type containerOptions struct {
+	// Attach every container label, regardless of labels/labels-regex
+	includeLabels bool
+	// Local log store backing `docker logs`
+	localMaxSize  int64
+	localMaxFiles int
+	// Reassembly of lines Docker split into partial entries
+	partialMaxSize      int64
+	partialFlushTimeout time.Duration
+	// Body parsing: "" (raw string), "json", "logfmt", "regex" or "grok"
+	parse string
+	// Compiled parse-pattern for regex and grok parsing
+	pattern *otelx.Pattern
+	// Application timestamp: fields of parsed lines it is read from, the format, and
+	// whether to look for it at the start of lines that were not parsed
+	timestampFields []string
+	timestampFormat *otelx.TimestampParser
+	timestampPrefix bool
+	// Trace correlation: fields of parsed lines holding the trace context, or a
+	// traceparent anywhere in lines that were not parsed
+	traceContext bool
+	traceFields  traceFields
+	// Top-level parsed keys lifted into record attributes instead of the body
+	parseAttributes []string
+	// Severity source: "auto" (parsed field or line prefix, then stream) or "stream"
+	severity       string
+	severityFields []string
+	// Multiline aggregation; disabled while both patterns are nil
+	multilineStart        *regexp.Regexp
+	multilineContinue     *regexp.Regexp
+	multilineMaxLines     int
+	multilineMaxBytes     int64
+	multilineFlushTimeout time.Duration
+	// service.name: explicit value, else the first source yielding one
+	serviceName        string
+	serviceNameSources []string
+	// Attribute schema; empty uses the plugin-level ATTRIBUTE_SCHEMA
+	attributeSchema string
+	// Bounded buffer between reading and emitting: policy when full and size in records
+	bufferPolicy string
+	bufferSize   int
+
+	// Effective value of every option above, defaults included, by log-opt name
+	values map[string]string
+}
+
+func parseOptions(opts map[string]string) (containerOptions, error) {
+	o := containerOptions{
+		localMaxSize:        defaultLocalMaxSize,
+		localMaxFiles:       defaultLocalMaxFiles,
+		partialMaxSize:      defaultPartialMaxSize,
+		partialFlushTimeout: defaultPartialFlushTimeout,
+		severity:            "auto",
+		severityFields:      []string{"level", "severity", "lvl", "log.level", "loglevel"},
+		traceContext:        true,
+		traceFields:         defaultTraceFields,
+
+		multilineMaxLines:     defaultMultilineMaxLines,
+		multilineMaxBytes:     defaultMultilineMaxBytes,
+		multilineFlushTimeout: defaultMultilineFlushTimeout,
+
+		serviceNameSources: defaultServiceNameSources,
+
+		bufferPolicy: defaultBufferPolicy,
+		bufferSize:   defaultBufferSize,
+	}
+	p := optParser{opts: opts, values: map[string]string{}}
+	p.bool("include-labels", &o.includeLabels)
+	p.size("local-max-size", &o.localMaxSize)
+	p.int("local-max-file", 1, &o.localMaxFiles)
+	p.size("partial-max-size", &o.partialMaxSize)
+	p.duration("partial-flush-timeout", &o.partialFlushTimeout)
+	p.enum("parse", &o.parse, "", "json", "logfmt", "regex", "grok")
+	p.pattern("parse-pattern", o.parse, &o.pattern)
+	p.list("parse-attributes", &o.parseAttributes)
+	p.enum("severity", &o.severity, "auto", "stream")
+	p.list("severity-field", &o.severityFields)
+	p.list("timestamp-field", &o.timestampFields)
+	p.timestampFormat("timestamp-format", &o.timestampFormat)
+	p.bool("timestamp-prefix", &o.timestampPrefix)
+	p.bool("trace-context", &o.traceContext)
+	p.list("trace-id-field", &o.traceFields.traceID)
+	p.list("span-id-field", &o.traceFields.spanID)
+	p.list("trace-flags-field", &o.traceFields.traceFlags)
+	p.list("traceparent-field", &o.traceFields.traceparent)
+
+	var preset string
+	p.enum("multiline", &preset, "", "java", "python", "go")
+	o.multilineStart, o.multilineContinue = multilinePresets[preset].start, multilinePresets[preset].cont
+	p.regexp("multiline-start", &o.multilineStart)
+	p.regexp("multiline-continue", &o.multilineContinue)
+	p.int("multiline-max-lines", 1, &o.multilineMaxLines)
+	p.size("multiline-max-bytes", &o.multilineMaxBytes)
+	p.duration("multiline-flush-timeout", &o.multilineFlushTimeout)
+
+	p.enum("attribute-schema", &o.attributeSchema, schemaLegacy, schemaSemconv, schemaBoth)
+	p.string("service-name", &o.serviceName)
+	p.list("service-name-sources", &o.serviceNameSources)
+	for _, src := range o.serviceNameSources {
+		if !validServiceNameSource(src) {
+			p.fail("service-name-sources", src)
+		}
+	}
+	p.enum("buffer-policy", &o.bufferPolicy, bufferBlock, bufferDropNewest, bufferDropOldest)
+	p.int("buffer-size", 1, &o.bufferSize)
+	o.values = p.values
+	return o, p.err
+}
+
+// optParser reads typed log-opts, keeping the first error it encounters.
+type optParser struct {
+	opts   map[string]string
+	values map[string]string
+	err    error
+}
+
+func (p *optParser) lookup(key string) (string, bool) {
+	if p.err != nil {
+		return "", false
+	}
+	v, ok := p.opts[key]
+	return v, ok
+}
+
+// record notes the effective value of an option once it has been parsed.
+func (p *optParser) record(key, v string) {
+	if p.values != nil {
+		p.values[key] = v
+	}
+}
+
+func (p *optParser) fail(key, v string) {
+	if p.err == nil {
+		p.err = fmt.Errorf("invalid %s %q", key, v)
+	}
+}
+
+// bool accepts true/false, 1/0 and yes/no.
+func (p *optParser) bool(key string, dst *bool) {
+	defer func() { p.record(key, strconv.FormatBool(*dst)) }()
+	if v, ok := p.lookup(key); ok {
+		switch strings.ToLower(strings.TrimSpace(v)) {
+		case "1", "true", "yes":
+			*dst = true
+		case "0", "false", "no", "":
+			*dst = false
+		default:
+			p.fail(key, v)
+		}
+	}
+}
+
+func (p *optParser) string(key string, dst *string) {
+	defer func() { p.record(key, *dst) }()
+	if v, ok := p.lookup(key); ok {
+		*dst = strings.TrimSpace(v)
+	}
+}
+
+// size parses a positive byte size such as 512k or 20m.
+func (p *optParser) size(key string, dst *int64) {
+	defer func() { p.record(key, strconv.FormatInt(*dst, 10)) }()
+	if v, ok := p.lookup(key); ok {
+		n, err := units.RAMInBytes(v)
+		if err != nil || n <= 0 {
+			p.fail(key, v)
+			return
+		}
+		*dst = n
+	}
+}
+
+func (p *optParser) int(key string, minimum int, dst *int) {
+	defer func() { p.record(key, strconv.Itoa(*dst)) }()
+	if v, ok := p.lookup(key); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < minimum {
+			p.fail(key, v)
+			return
+		}
+		*dst = n
+	}
+}
+
+// duration parses a positive Go duration such as 500ms or 5s.
+func (p *optParser) duration(key string, dst *time.Duration) {
+	defer func() { p.record(key, dst.String()) }()
+	if v, ok := p.lookup(key); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			p.fail(key, v)
+			return
+		}
+		*dst = d
+	}
+}
+
+// enum accepts one of the allowed values, case-insensitively.
+func (p *optParser) enum(key string, dst *string, allowed ...string) {
+	defer func() { p.record(key, *dst) }()
+	if v, ok := p.lookup(key); ok {
+		lv := strings.ToLower(strings.TrimSpace(v))
+		if !slices.Contains(allowed, lv) {
+			p.fail(key, v)
+			return
+		}
+		*dst = lv
+	}
+}
+
+// list parses a comma-separated list, dropping empty items.
+func (p *optParser) list(key string, dst *[]string) {
+	defer func() { p.record(key, strings.Join(*dst, ",")) }()
+	if v, ok := p.lookup(key); ok {
+		var items []string
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		*dst = items
+	}
+}
+
+func (p *optParser) regexp(key string, dst **regexp.Regexp) {
+	defer func() {
+		if *dst != nil {
+			p.record(key, (*dst).String())
+		} else {
+			p.record(key, "")
+		}
+	}()
+	if v, ok := p.lookup(key); ok {
+		re, err := regexp.Compile(v)
+		if err != nil {
+			p.err = fmt.Errorf("invalid %s %q: %w", key, v, err)
+			return
+		}
+		*dst = re
+	}
+}
+
+// pattern compiles the expression for the regex or grok parser; it is required by
+// those and ignored otherwise.
+func (p *optParser) pattern(key, syntax string, dst **otelx.Pattern) {
+	defer func() {
+		if *dst != nil {
+			p.record(key, (*dst).String())
+		} else {
+			p.record(key, "")
+		}
+	}()
+	if syntax != "regex" && syntax != "grok" {
+		return
+	}
+	v, ok := p.lookup(key)
+	if p.err != nil {
+		return
+	}
+	if !ok || strings.TrimSpace(v) == "" {
+		p.err = fmt.Errorf("parse=%s requires %s", syntax, key)
+		return
+	}
+	compile := otelx.CompileRegex
+	if syntax == "grok" {
+		compile = otelx.CompileGrok
+	}
+	pat, err := compile(v)
+	if err != nil {
+		p.err = fmt.Errorf("invalid %s %q: %w", key, v, err)
+		return
+	}
+	*dst = pat
+}
+
+// timestampFormat accepts the formats of otelx.NewTimestampParser, defaulting to auto.
+func (p *optParser) timestampFormat(key string, dst **otelx.TimestampParser) {
+	format := "auto"
+	if v, ok := p.lookup(key); ok && strings.TrimSpace(v) != "" {
+		format = strings.TrimSpace(v)
+	}
+	defer func() { p.record(key, format) }()
+	tp, err := otelx.NewTimestampParser(format)
+	if err != nil {
+		p.err = fmt.Errorf("invalid %s %q: %w", key, format, err)
+		return
+	}
+	*dst = tp
+}
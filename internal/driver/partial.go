@@ -0,0 +1,83 @@
+package driver
+
+import (
+	"sort"
+	"time"
+
+	"github.com/docker/docker/api/types/plugins/logdriver"
+)
+
+const (
+	defaultPartialMaxSize      = 1024 * 1024
+	defaultPartialFlushTimeout = 5 * time.Second
+)
+
+// partialAssembler joins the fragments Docker emits for lines longer than 16 KiB
+// (entries carrying PartialLogMetadata) back into single entries.
+type partialAssembler struct {
+	maxSize int64
+	timeout time.Duration
+	pending map[string]*partialBuffer
+}
+
+type partialBuffer struct {
+	entry   *logdriver.LogEntry
+	started time.Time
+}
+
+func newPartialAssembler(maxSize int64, timeout time.Duration) *partialAssembler {
+	return &partialAssembler{maxSize: maxSize, timeout: timeout, pending: map[string]*partialBuffer{}}
+}
+
+// Add consumes one decoded entry and returns the entries that are complete. A buffer that
+// grows past maxSize is returned as is and assembly continues with the next fragment.
+func (a *partialAssembler) Add(e *logdriver.LogEntry, now time.Time) []*logdriver.LogEntry {
+	meta := e.PartialLogMetadata
+	if meta == nil || meta.Id == "" {
+		return []*logdriver.LogEntry{e}
+	}
+	buf, ok := a.pending[meta.Id]
+	if !ok {
+		first := *e
+		first.Line = append([]byte(nil), e.Line...)
+		first.Partial = false
+		first.PartialLogMetadata = nil
+		buf = &partialBuffer{entry: &first, started: now}
+		a.pending[meta.Id] = buf
+	} else {
+		buf.entry.Line = append(buf.entry.Line, e.Line...)
+	}
+
+	if meta.Last || (a.maxSize > 0 && int64(len(buf.entry.Line)) >= a.maxSize) {
+		delete(a.pending, meta.Id)
+		return []*logdriver.LogEntry{buf.entry}
+	}
+	return nil
+}
+
+// Expired returns, oldest first, the buffers whose final fragment did not arrive within
+// the timeout.
+func (a *partialAssembler) Expired(now time.Time) []*logdriver.LogEntry {
+	return a.take(func(buf *partialBuffer) bool { return now.Sub(buf.started) >= a.timeout })
+}
+
+// Flush returns every pending buffer, used when the FIFO is closed.
+func (a *partialAssembler) Flush() []*logdriver.LogEntry {
+	return a.take(func(*partialBuffer) bool { return true })
+}
+
+func (a *partialAssembler) take(match func(*partialBuffer) bool) []*logdriver.LogEntry {
+	var bufs []*partialBuffer
+	for id, buf := range a.pending {
+		if match(buf) {
+			bufs = append(bufs, buf)
+			delete(a.pending, id)
+		}
+	}
+	sort.Slice(bufs, func(i, j int) bool { return bufs[i].started.Before(bufs[j].started) })
+	out := make([]*logdriver.LogEntry, len(bufs))
+	for i, buf := range bufs {
+		out[i] = buf.entry
+	}
+	return out
+}
@@ -0,0 +1,66 @@
+package driver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/plugins/logdriver"
+)
+
+func fragment(id string, ordinal int32, last bool, line string) *logdriver.LogEntry {
+	return &logdriver.LogEntry{
+		Source:             "stdout",
+		Line:               []byte(line),
+		Partial:            !last,
+		PartialLogMetadata: &logdriver.PartialLogEntryMetadata{Id: id, Ordinal: ordinal, Last: last},
+	}
+}
+
+func TestPartialAssembler(t *testing.T) {
+	now := time.Now()
+	a := newPartialAssembler(1024, time.Second)
+
+	if out := a.Add(&logdriver.LogEntry{Line: []byte("whole")}, now); len(out) != 1 || string(out[0].Line) != "whole" {
+		t.Fatalf("plain entry=%v", out)
+	}
+
+	if out := a.Add(fragment("a", 1, false, `{"x":`), now); len(out) != 0 {
+		t.Fatalf("first fragment emitted early: %v", out)
+	}
+	// An interleaved fragment stream with a different id is assembled independently.
+	if out := a.Add(fragment("b", 1, false, "other-"), now); len(out) != 0 {
+		t.Fatalf("b emitted early: %v", out)
+	}
+	out := a.Add(fragment("a", 2, true, `1}`), now)
+	if len(out) != 1 || string(out[0].Line) != `{"x":1}` || out[0].PartialLogMetadata != nil || out[0].Partial {
+		t.Fatalf("assembled=%+v", out)
+	}
+
+	// Missing final fragment is flushed after the timeout.
+	if out := a.Expired(now.Add(500 * time.Millisecond)); len(out) != 0 {
+		t.Fatalf("expired too early: %v", out)
+	}
+	if out := a.Expired(now.Add(time.Second)); len(out) != 1 || string(out[0].Line) != "other-" {
+		t.Fatalf("expired=%v", out)
+	}
+	if len(a.pending) != 0 {
+		t.Fatalf("pending not released: %v", a.pending)
+	}
+}
+
+func TestPartialAssembler_SizeCap(t *testing.T) {
+	now := time.Now()
+	a := newPartialAssembler(8, time.Second)
+	if out := a.Add(fragment("a", 1, false, "12345"), now); len(out) != 0 {
+		t.Fatalf("emitted below cap: %v", out)
+	}
+	if out := a.Add(fragment("a", 2, false, "6789"), now); len(out) != 1 || string(out[0].Line) != "123456789" {
+		t.Fatalf("cap not enforced: %v", out)
+	}
+	if out := a.Add(fragment("a", 3, true, "end"), now); len(out) != 1 || string(out[0].Line) != "end" {
+		t.Fatalf("tail after cap=%v", out)
+	}
+	if out := a.Flush(); len(out) != 0 {
+		t.Fatalf("flush=%v", out)
+	}
+}
@@ -0,0 +1,242 @@
+package driver
+
+import (
+	"time"
+
+	"github.com/docker/docker/api/types/plugins/logdriver"
+	olog "go.opentelemetry.io/otel/log"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/moritzloewenstein/otel-docker-logging-driver/internal/otelx"
+)
+
+// buildRecord maps a complete Docker entry onto an OTEL log record according to the
+// container's options. The span context holds the trace the line was correlated
+// with, if any.
+func buildRecord(in *dockerInput, entry *logdriver.LogEntry) (olog.Record, trace.SpanContext) {
+	info := in.info
+
+	attrs := containerAttributes(info, entry.Source, in.opts.attributeSchema)
+	attrs = append(attrs, in.attrs...)
+
+	line := string(entry.Line)
+	var fields map[string]any
+	switch in.opts.parse {
+	case "json":
+		fields, _ = otelx.ParseJSON(entry.Line)
+	case "logfmt":
+		fields, _ = otelx.ParseLogfmt(entry.Line)
+	case "regex", "grok":
+		fields, _ = in.opts.pattern.Match(line)
+	}
+
+	var ts time.Time
+	if fields == nil && in.opts.timestampPrefix {
+		// The prefix is dropped from the body so the level after it can be detected.
+		if t, rest, ok := in.opts.timestampFormat.Prefix(line); ok {
+			ts, line = t, rest
+		}
+	}
+	body := olog.StringValue(line)
+
+	severity, severityText := detectSeverity(in.opts, entry.Source, line, fields)
+
+	var sc trace.SpanContext
+	if in.opts.traceContext {
+		switch {
+		case fields == nil:
+			sc, _ = otelx.FindTraceparent(line)
+		case in.opts.parse == "json" || in.opts.parse == "logfmt":
+			sc, _ = fieldSpanContext(in.opts.traceFields, fields)
+		}
+	}
+	if fields != nil {
+		for _, k := range in.opts.parseAttributes {
+			if v, ok := fields[k]; ok {
+				attrs = append(attrs, olog.KeyValue{Key: k, Value: otelx.Value(v)})
+				delete(fields, k)
+			}
+		}
+		switch in.opts.parse {
+		case "logfmt":
+			ts = fieldTimestamp(in.opts, fields, []string{"time", "ts"})
+			body, attrs = logfmtRecord(fields, attrs)
+		case "regex", "grok":
+			body, ts, sc, attrs = patternRecord(in.opts, fields, severityText, body, attrs)
+		default:
+			ts = fieldTimestamp(in.opts, fields, nil)
+			body = otelx.MapValue(fields)
+		}
+	}
+
+	dockerTime := time.Unix(0, entry.TimeNano)
+	if ts.IsZero() {
+		ts = dockerTime
+	}
+	rec := otelx.BuildRecordValue(ts, body, severity, attrs...)
+	// The application's time, if any, is the event time; Docker's is when it was
+	// observed, whether or not the application logged one.
+	rec.SetObservedTimestamp(dockerTime)
+	rec.SetSeverityText(severityText)
+	return rec, sc
+}
+
+// fieldTimestamp reads the application's timestamp from the first of the
+// timestamp-field fields, or of defaults if that option is unset, holding one in
+// timestamp-format. It returns the zero time if there is none.
+func fieldTimestamp(opts containerOptions, fields map[string]any, defaults []string) time.Time {
+	keys := opts.timestampFields
+	if keys == nil {
+		keys = defaults
+	}
+	for _, k := range keys {
+		if v, ok := fields[k]; ok {
+			if t, ok := opts.timestampFormat.Parse(v); ok {
+				return t
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// logfmtRecord maps logfmt pairs onto the record: msg (or message) becomes the body
+// and the other pairs attributes; without one the pairs make up a map body.
+func logfmtRecord(fields map[string]any, attrs []olog.KeyValue) (olog.Value, []olog.KeyValue) {
+	for _, k := range []string{"msg", "message"} {
+		msg, ok := fields[k].(string)
+		if !ok {
+			continue
+		}
+		delete(fields, k)
+		for _, kv := range otelx.MapValue(fields).AsMap() {
+			attrs = append(attrs, kv)
+		}
+		return olog.StringValue(msg), attrs
+	}
+	return otelx.MapValue(fields), attrs
+}
+
+// patternRecord maps the fields captured by a regex or grok pattern onto the record.
+// The reserved names set record fields: level has been read as the severity,
+// message becomes the body (else the whole line stays the body), timestamp the
+// record's timestamp, and trace_id, span_id and trace_flags (or traceparent) its
+// trace context. Reserved fields that cannot be used, and all other fields, become
+// attributes.
+func patternRecord(opts containerOptions, fields map[string]any, severityText string, line olog.Value, attrs []olog.KeyValue) (olog.Value, time.Time, trace.SpanContext, []olog.KeyValue) {
+	if severityText != "" {
+		delete(fields, "level")
+	}
+	body := line
+	if msg, ok := fields["message"].(string); ok {
+		body = olog.StringValue(msg)
+		delete(fields, "message")
+	}
+	var ts time.Time
+	if v, ok := fields["timestamp"]; ok {
+		if t, ok := opts.timestampFormat.Parse(v); ok {
+			ts = t
+			delete(fields, "timestamp")
+		}
+	}
+	var sc trace.SpanContext
+	if opts.traceContext {
+		var used []string
+		sc, used = fieldSpanContext(patternTraceFields, fields)
+		for _, k := range used {
+			delete(fields, k)
+		}
+	}
+	if len(fields) > 0 {
+		attrs = append(attrs, otelx.MapValue(fields).AsMap()...)
+	}
+	return body, ts, sc, attrs
+}
+
+// traceFields names the fields of parsed lines the trace context is read from, each
+// list in order of preference.
+type traceFields struct {
+	traceID     []string
+	spanID      []string
+	traceFlags  []string
+	traceparent []string
+}
+
+// defaultTraceFields covers the names used by the OTel log bridges and common
+// logging libraries; the trace-id-field and related log-opts replace them.
+var defaultTraceFields = traceFields{
+	traceID:     []string{"trace_id", "traceId", "trace.id"},
+	spanID:      []string{"span_id", "spanId", "span.id"},
+	traceFlags:  []string{"trace_flags", "traceFlags", "trace.flags"},
+	traceparent: []string{"traceparent"},
+}
+
+// patternTraceFields are the reserved names of regex and grok patterns.
+var patternTraceFields = traceFields{
+	traceID:     []string{"trace_id"},
+	spanID:      []string{"span_id"},
+	traceFlags:  []string{"trace_flags"},
+	traceparent: []string{"traceparent"},
+}
+
+// fieldSpanContext reads the trace context from parsed fields: a valid traceparent,
+// else the first valid trace ID along with the span ID and flags, if any. Without a
+// trace ID there is no trace context. It also returns the fields it used.
+func fieldSpanContext(keys traceFields, fields map[string]any) (trace.SpanContext, []string) {
+	for _, k := range keys.traceparent {
+		if v, ok := fields[k].(string); ok {
+			if sc, ok := otelx.ParseTraceparent(v); ok {
+				return sc, []string{k}
+			}
+		}
+	}
+	var cfg trace.SpanContextConfig
+	var used []string
+	for _, k := range keys.traceID {
+		if id, ok := otelx.ParseTraceID(fields[k]); ok {
+			cfg.TraceID = id
+			used = append(used, k)
+			break
+		}
+	}
+	if used == nil {
+		return trace.SpanContext{}, nil
+	}
+	for _, k := range keys.spanID {
+		if id, ok := otelx.ParseSpanID(fields[k]); ok {
+			cfg.SpanID = id
+			used = append(used, k)
+			break
+		}
+	}
+	for _, k := range keys.traceFlags {
+		if flags, ok := otelx.ParseTraceFlags(fields[k]); ok {
+			cfg.TraceFlags = flags
+			used = append(used, k)
+			break
+		}
+	}
+	return trace.NewSpanContext(cfg), used
+}
+
+// detectSeverity reads the level from parsed fields or the start of the line, falling
+// back to stdout=INFO, stderr=ERROR.
+func detectSeverity(opts containerOptions, source, line string, fields map[string]any) (olog.Severity, string) {
+	if opts.severity == "auto" {
+		keys := opts.severityFields
+		if opts.pattern != nil {
+			// Patterns name their fields, so the level is always the reserved one.
+			keys = []string{"level"}
+		}
+		if fields != nil {
+			if sev, text, ok := otelx.FieldSeverity(fields, keys); ok {
+				return sev, text
+			}
+		} else if sev, text, ok := otelx.DetectSeverity(line); ok {
+			return sev, text
+		}
+	}
+	if source == "stderr" {
+		return olog.SeverityError, ""
+	}
+	return olog.SeverityInfo, ""
+}
@@ -0,0 +1,216 @@
+package driver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/plugins/logdriver"
+	"github.com/docker/docker/daemon/logger"
+	olog "go.opentelemetry.io/otel/log"
+)
+
+func testInput(t *testing.T, config map[string]string) *dockerInput {
+	t.Helper()
+	info := logger.Info{ContainerID: "cid123", ContainerName: "/demo", ContainerImageName: "busybox", Config: config}
+	opts, err := parseOptions(config)
+	if err != nil {
+		t.Fatalf("parseOptions: %v", err)
+	}
+	return &dockerInput{info: info, opts: opts}
+}
+
+func TestBuildRecord_Severity(t *testing.T) {
+	cases := []struct {
+		opts   map[string]string
+		source string
+		line   string
+		sev    olog.Severity
+		text   string
+	}{
+		// Level token in text wins over the stream.
+		{nil, "stderr", "DEBUG cache warm", olog.SeverityDebug, "DEBUG"},
+		{nil, "stdout", "<12>disk", olog.SeverityWarn, "warning"},
+		// Fallback to stream mapping.
+		{nil, "stderr", "oops", olog.SeverityError, ""},
+		{nil, "stdout", "hello", olog.SeverityInfo, ""},
+		// Parsed field.
+		{map[string]string{"parse": "json"}, "stderr", `{"level":"info","msg":"ok"}`, olog.SeverityInfo, "info"},
+		{map[string]string{"parse": "json", "severity-field": "sev"}, "stdout", `{"sev":"fatal"}`, olog.SeverityFatal, "fatal"},
+		{map[string]string{"parse": "logfmt"}, "stdout", `level=warn msg="disk low"`, olog.SeverityWarn, "warn"},
+		// Detection disabled.
+		{map[string]string{"severity": "stream"}, "stderr", "DEBUG cache warm", olog.SeverityError, ""},
+	}
+	for _, c := range cases {
+		rec, _ := buildRecord(testInput(t, c.opts), &logdriver.LogEntry{Source: c.source, Line: []byte(c.line)})
+		if rec.Severity() != c.sev || rec.SeverityText() != c.text {
+			t.Fatalf("%q (%v): severity=%v text=%q want %v %q", c.line, c.opts, rec.Severity(), rec.SeverityText(), c.sev, c.text)
+		}
+	}
+}
+
+func TestBuildRecord_Logfmt(t *testing.T) {
+	in := testInput(t, map[string]string{"parse": "logfmt"})
+	docker := time.Date(2024, 5, 1, 12, 0, 1, 0, time.UTC)
+	entry := func(line string) *logdriver.LogEntry {
+		return &logdriver.LogEntry{Source: "stdout", Line: []byte(line), TimeNano: docker.UnixNano()}
+	}
+
+	rec, _ := buildRecord(in, entry(`time=2024-05-01T12:00:00.5Z level=info msg="started \"api\"" dur=12ms`))
+	if rec.Body().AsString() != `started "api"` {
+		t.Fatalf("body=%v", rec.Body())
+	}
+	attrs := stringAttrs(rec)
+	if attrs["dur"] != "12ms" || attrs["level"] != "info" || attrs["msg"] != "" {
+		t.Fatalf("attrs=%v", attrs)
+	}
+	if want := time.Date(2024, 5, 1, 12, 0, 0, 5e8, time.UTC); !rec.Timestamp().Equal(want) || !rec.ObservedTimestamp().Equal(docker) {
+		t.Fatalf("timestamp=%v observed=%v", rec.Timestamp(), rec.ObservedTimestamp())
+	}
+
+	// Without a message the pairs make up the body.
+	rec, _ = buildRecord(in, entry("at=info method=GET status=200"))
+	if rec.Body().Kind() != olog.KindMap || len(rec.Body().AsMap()) != 3 || !rec.Timestamp().Equal(docker) {
+		t.Fatalf("body=%v timestamp=%v", rec.Body(), rec.Timestamp())
+	}
+
+	// Anything else stays a string.
+	if rec, _ := buildRecord(in, entry("plain text")); rec.Body().AsString() != "plain text" {
+		t.Fatalf("fallback body=%v", rec.Body())
+	}
+}
+
+func TestBuildRecord_Pattern(t *testing.T) {
+	docker := time.Date(2024, 5, 1, 12, 0, 1, 0, time.UTC)
+	entry := func(line string) *logdriver.LogEntry {
+		return &logdriver.LogEntry{Source: "stdout", Line: []byte(line), TimeNano: docker.UnixNano()}
+	}
+	for _, opts := range []map[string]string{
+		{"parse": "regex", "parse-pattern": `^(?P<timestamp>\S+) (?P<level>\w+) (?P<trace_id>\w+) (?P<user>\w+): (?P<message>.*)$`},
+		{"parse": "grok", "parse-pattern": `%{TIMESTAMP_ISO8601:timestamp} %{LOGLEVEL:level} %{WORD:trace_id} %{USER:user}: %{GREEDYDATA:message}`},
+	} {
+		in := testInput(t, opts)
+		rec, sc := buildRecord(in, entry("2024-05-01T12:00:00Z WARN 4BF92F3577B34DA6A3CE929D0E0E4736 alice: disk low"))
+		if rec.Body().AsString() != "disk low" || rec.Severity() != olog.SeverityWarn || rec.SeverityText() != "WARN" {
+			t.Fatalf("%s: body=%v severity=%v %q", opts["parse"], rec.Body(), rec.Severity(), rec.SeverityText())
+		}
+		if !rec.Timestamp().Equal(docker.Add(-time.Second)) || !rec.ObservedTimestamp().Equal(docker) {
+			t.Fatalf("%s: timestamp=%v observed=%v", opts["parse"], rec.Timestamp(), rec.ObservedTimestamp())
+		}
+		if sc.TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Fatalf("%s: trace=%v", opts["parse"], sc.TraceID())
+		}
+		attrs := stringAttrs(rec)
+		if attrs["user"] != "alice" || attrs["message"] != "" || attrs["level"] != "" || attrs["timestamp"] != "" || attrs["trace_id"] != "" {
+			t.Fatalf("%s: attrs=%v", opts["parse"], attrs)
+		}
+
+		// Unusable reserved fields stay attributes; unmatched lines stay strings.
+		rec, sc = buildRecord(in, entry("2024-05-01T12:00:00Z INFO nothex bob: ok"))
+		if attrs := stringAttrs(rec); sc.HasTraceID() || attrs["trace_id"] != "nothex" {
+			t.Fatalf("%s: trace=%v attrs=%v", opts["parse"], sc.TraceID(), attrs)
+		}
+		if rec, _ := buildRecord(in, entry("plain text")); rec.Body().AsString() != "plain text" || rec.Severity() != olog.SeverityInfo {
+			t.Fatalf("%s: fallback body=%v", opts["parse"], rec.Body())
+		}
+	}
+
+	for _, opts := range []map[string]string{
+		{"parse": "grok"},
+		{"parse": "regex", "parse-pattern": `(\w+)`},
+		{"parse": "grok", "parse-pattern": `%{NOPE:x}`},
+	} {
+		if _, err := parseOptions(opts); err == nil {
+			t.Fatalf("parseOptions(%v) accepted", opts)
+		}
+	}
+}
+
+// stringAttrs returns the record's attributes by key, as strings.
+func stringAttrs(rec olog.Record) map[string]string {
+	attrs := map[string]string{}
+	rec.WalkAttributes(func(kv olog.KeyValue) bool {
+		attrs[kv.Key] = kv.Value.AsString()
+		return true
+	})
+	return attrs
+}
+
+func TestBuildRecord_Timestamp(t *testing.T) {
+	docker := time.Date(2024, 5, 1, 12, 0, 1, 0, time.UTC)
+	app := time.Date(2024, 5, 1, 12, 0, 0, 250e6, time.UTC)
+	cases := []struct {
+		opts map[string]string
+		line string
+		ts   time.Time
+		body string
+	}{
+		// Fields of parsed lines.
+		{map[string]string{"parse": "json", "timestamp-field": "ts,time"}, `{"time":1714564800250,"msg":"ok"}`, app, ""},
+		{map[string]string{"parse": "json", "timestamp-field": "ts", "timestamp-format": "unix_ms"}, `{"ts":"1714564800250"}`, app, ""},
+		{map[string]string{"parse": "json"}, `{"time":"2024-05-01T12:00:00.25Z"}`, docker, ""},
+		{map[string]string{"parse": "logfmt"}, `ts=1714564800.25 msg=ok`, app, "ok"},
+		{map[string]string{"parse": "logfmt", "timestamp-field": "at", "timestamp-format": "2006-01-02 15:04:05.000"}, `at="2024-05-01 12:00:00.250" msg=ok`, app, "ok"},
+		// Prefix of plain lines, which is dropped from the body.
+		{map[string]string{"timestamp-prefix": "true"}, "2024-05-01T12:00:00.25Z WARN disk low", app, "WARN disk low"},
+		{map[string]string{"timestamp-prefix": "true"}, "1714564800.25 ok", app, "ok"},
+		{map[string]string{"timestamp-prefix": "true", "timestamp-format": "rfc3339"}, "1714564800.25 ok", docker, "1714564800.25 ok"},
+		{map[string]string{"timestamp-prefix": "true", "parse": "json"}, "2024-05-01T12:00:00.25Z not json", app, "not json"},
+		{nil, "2024-05-01T12:00:00.25Z ok", docker, "2024-05-01T12:00:00.25Z ok"},
+	}
+	for _, c := range cases {
+		rec, _ := buildRecord(testInput(t, c.opts), &logdriver.LogEntry{Source: "stdout", Line: []byte(c.line), TimeNano: docker.UnixNano()})
+		if !rec.Timestamp().Equal(c.ts) {
+			t.Fatalf("%q (%v): timestamp=%v want %v", c.line, c.opts, rec.Timestamp(), c.ts)
+		}
+		if !rec.ObservedTimestamp().Equal(docker) {
+			t.Fatalf("%q (%v): observed=%v", c.line, c.opts, rec.ObservedTimestamp())
+		}
+		if c.body != "" && rec.Body().AsString() != c.body {
+			t.Fatalf("%q (%v): body=%v", c.line, c.opts, rec.Body())
+		}
+	}
+
+	// The level after a dropped prefix is detected.
+	rec, _ := buildRecord(testInput(t, map[string]string{"timestamp-prefix": "true"}), &logdriver.LogEntry{Source: "stdout", Line: []byte("2024-05-01T12:00:00Z WARN disk low")})
+	if rec.Severity() != olog.SeverityWarn {
+		t.Fatalf("severity=%v", rec.Severity())
+	}
+
+	if _, err := parseOptions(map[string]string{"timestamp-format": "soon"}); err == nil {
+		t.Fatalf("invalid timestamp-format accepted")
+	}
+}
+
+func TestBuildRecord_TraceContext(t *testing.T) {
+	const traceID, spanID = "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"
+	cases := []struct {
+		opts  map[string]string
+		line  string
+		trace string
+		span  string
+	}{
+		{map[string]string{"parse": "json"}, `{"traceId":"4BF92F3577B34DA6A3CE929D0E0E4736","spanId":"00F067AA0BA902B7"}`, traceID, spanID},
+		{map[string]string{"parse": "json"}, `{"traceparent":"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}`, traceID, spanID},
+		{map[string]string{"parse": "json"}, `{"trace_id":"not-hex","span_id":"00f067aa0ba902b7"}`, "", ""},
+		{map[string]string{"parse": "json"}, `{"trace_id":"00000000000000000000000000000000"}`, "", ""},
+		{map[string]string{"parse": "json", "trace-id-field": "dd.trace", "span-id-field": "dd.span"}, `{"dd.trace":"4bf92f3577b34da6a3ce929d0e0e4736","dd.span":"00f067aa0ba902b7","trace_id":"x"}`, traceID, spanID},
+		{map[string]string{"parse": "logfmt"}, `msg=hi trace_id=4bf92f3577b34da6a3ce929d0e0e4736`, traceID, ""},
+		{nil, "[00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01] GET /", traceID, spanID},
+		{map[string]string{"parse": "grok", "parse-pattern": `%{NOTSPACE:traceparent} %{GREEDYDATA:message}`}, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01 GET /", traceID, spanID},
+		{map[string]string{"trace-context": "false"}, "traceparent=00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "", ""},
+		{map[string]string{"parse": "json", "trace-context": "false"}, `{"trace_id":"4bf92f3577b34da6a3ce929d0e0e4736"}`, "", ""},
+	}
+	for _, c := range cases {
+		_, sc := buildRecord(testInput(t, c.opts), &logdriver.LogEntry{Source: "stdout", Line: []byte(c.line)})
+		var gotTrace, gotSpan string
+		if sc.HasTraceID() {
+			gotTrace = sc.TraceID().String()
+		}
+		if sc.HasSpanID() {
+			gotSpan = sc.SpanID().String()
+		}
+		if gotTrace != c.trace || gotSpan != c.span {
+			t.Fatalf("%q (%v): trace=%q span=%q want %q %q", c.line, c.opts, gotTrace, gotSpan, c.trace, c.span)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package driver
+
+import (
+	"strings"
+
+	"github.com/docker/docker/daemon/logger"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/resource"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+)
+
+// defaultServiceNameSources is the service.name resolution chain used unless the
+// service-name-sources log-opt replaces it.
+var defaultServiceNameSources = []string{"env:OTEL_SERVICE_NAME", "label:com.docker.compose.service", "name"}
+
+// containerResource describes the container a record came from.
+func containerResource(info logger.Info, opts containerOptions) *resource.Resource {
+	img := parseImageRef(info.ContainerImageName)
+	attrs := []attribute.KeyValue{
+		semconv.ServiceName(serviceName(info, opts)),
+		semconv.ContainerID(info.ContainerID),
+		semconv.ContainerName(info.Name()),
+		semconv.ContainerImageName(img.name),
+	}
+	if img.tag != "" {
+		attrs = append(attrs, semconv.ContainerImageTags(img.tag))
+	}
+	if host, err := info.Hostname(); err == nil && host != "" {
+		attrs = append(attrs, semconv.HostName(host))
+	}
+	res, _ := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL, attrs...))
+	return res
+}
+
+// serviceName resolves service.name from the explicit service-name log-opt, then the
+// first source of the chain that yields a value: env:<VAR> reads the container
+// environment, label:<key> a container label and name the container name.
+func serviceName(info logger.Info, opts containerOptions) string {
+	if opts.serviceName != "" {
+		return opts.serviceName
+	}
+	for _, src := range opts.serviceNameSources {
+		kind, key, _ := strings.Cut(src, ":")
+		var v string
+		switch kind {
+		case "env":
+			v = containerEnv(info, key)
+		case "label":
+			v = info.ContainerLabels[key]
+		case "name":
+			v = info.Name()
+		}
+		if v != "" {
+			return v
+		}
+	}
+	return info.Name()
+}
+
+func validServiceNameSource(src string) bool {
+	kind, key, _ := strings.Cut(src, ":")
+	switch kind {
+	case "env", "label":
+		return key != ""
+	case "name":
+		return key == ""
+	}
+	return false
+}
+
+// containerEnv looks up a variable in the container's KEY=value environment.
+func containerEnv(info logger.Info, key string) string {
+	for _, kv := range info.ContainerEnv {
+		if k, v, ok := strings.Cut(kv, "="); ok && k == key {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,79 @@
+package driver
+
+import (
+	"testing"
+
+	"github.com/docker/docker/daemon/logger"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+)
+
+func TestServiceName(t *testing.T) {
+	info := logger.Info{
+		ContainerName:   "/web-1",
+		ContainerEnv:    []string{"PATH=/bin", "OTEL_SERVICE_NAME=checkout"},
+		ContainerLabels: map[string]string{"com.docker.compose.service": "web", "team": "payments"},
+	}
+	cases := []struct {
+		opts map[string]string
+		want string
+	}{
+		{nil, "checkout"},
+		{map[string]string{"service-name": "explicit"}, "explicit"},
+		{map[string]string{"service-name-sources": "label:com.docker.compose.service,name"}, "web"},
+		{map[string]string{"service-name-sources": "env:MISSING,label:team"}, "payments"},
+		{map[string]string{"service-name-sources": "label:missing"}, "web-1"},
+	}
+	for _, c := range cases {
+		opts, err := parseOptions(c.opts)
+		if err != nil {
+			t.Fatalf("parseOptions(%v): %v", c.opts, err)
+		}
+		if got := serviceName(info, opts); got != c.want {
+			t.Fatalf("serviceName(%v)=%q want %q", c.opts, got, c.want)
+		}
+	}
+
+	// Without env or compose label the container name is used.
+	opts, _ := parseOptions(nil)
+	if got := serviceName(logger.Info{ContainerName: "/solo"}, opts); got != "solo" {
+		t.Fatalf("fallback=%q", got)
+	}
+
+	for _, bad := range []string{"env:", "label", "name:x", "image"} {
+		if _, err := parseOptions(map[string]string{"service-name-sources": bad}); err == nil {
+			t.Fatalf("service-name-sources=%q accepted", bad)
+		}
+	}
+}
+
+func TestContainerResource(t *testing.T) {
+	info := logger.Info{ContainerID: "cid123", ContainerName: "/demo", ContainerImageName: "nginx:1.27"}
+	opts, _ := parseOptions(nil)
+	res := containerResource(info, opts)
+	got := map[string]string{}
+	for _, kv := range res.Attributes() {
+		got[string(kv.Key)] = kv.Value.Emit()
+	}
+	if got[string(semconv.ServiceNameKey)] != "demo" ||
+		got[string(semconv.ContainerIDKey)] != "cid123" ||
+		got[string(semconv.ContainerNameKey)] != "demo" ||
+		got[string(semconv.ContainerImageNameKey)] != "nginx" ||
+		got[string(semconv.ContainerImageTagsKey)] != `["1.27"]` ||
+		got[string(semconv.HostNameKey)] == "" {
+		t.Fatalf("resource=%v", got)
+	}
+
+	// An untagged image has no tags attribute.
+	info.ContainerImageName = "registry:5000/org/app@sha256:0123"
+	res = containerResource(info, opts)
+	for _, kv := range res.Attributes() {
+		switch kv.Key {
+		case semconv.ContainerImageNameKey:
+			if kv.Value.AsString() != "registry:5000/org/app" {
+				t.Fatalf("image name=%q", kv.Value.AsString())
+			}
+		case semconv.ContainerImageTagsKey:
+			t.Fatalf("tags=%v", kv.Value.Emit())
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package otelx
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	olog "go.opentelemetry.io/otel/log"
+)
+
+// ParseJSON decodes a line holding a single JSON object. It reports false for anything
+// else (plain text, arrays, scalars, trailing garbage) so callers can fall back to a
+// string body.
+func ParseJSON(line []byte) (map[string]any, bool) {
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 || line[0] != '{' {
+		return nil, false
+	}
+	dec := json.NewDecoder(bytes.NewReader(line))
+	dec.UseNumber()
+	var m map[string]any
+	if err := dec.Decode(&m); err != nil || dec.More() {
+		return nil, false
+	}
+	return m, true
+}
+
+// Value converts a decoded JSON value into a log value, preserving nested objects as
+// maps and arrays as slices.
+func Value(v any) olog.Value {
+	switch t := v.(type) {
+	case nil:
+		return olog.Value{}
+	case string:
+		return olog.StringValue(t)
+	case bool:
+		return olog.BoolValue(t)
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return olog.Int64Value(i)
+		}
+		if f, err := t.Float64(); err == nil {
+			return olog.Float64Value(f)
+		}
+		return olog.StringValue(t.String())
+	case float64:
+		return olog.Float64Value(t)
+	case int64:
+		return olog.Int64Value(t)
+	case int:
+		return olog.IntValue(t)
+	case map[string]any:
+		return MapValue(t)
+	case []any:
+		vals := make([]olog.Value, len(t))
+		for i, e := range t {
+			vals[i] = Value(e)
+		}
+		return olog.SliceValue(vals...)
+	default:
+		return olog.StringValue(fmt.Sprint(t))
+	}
+}
+
+// MapValue converts an object into a map value with keys in sorted order.
+func MapValue(m map[string]any) olog.Value {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	kvs := make([]olog.KeyValue, len(keys))
+	for i, k := range keys {
+		kvs[i] = olog.KeyValue{Key: k, Value: Value(m[k])}
+	}
+	return olog.MapValue(kvs...)
+}
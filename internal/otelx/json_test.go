@@ -0,0 +1,42 @@
+package otelx
+
+import (
+	"testing"
+
+	olog "go.opentelemetry.io/otel/log"
+)
+
+func TestParseJSON(t *testing.T) {
+	for _, line := range []string{"", "plain text", "[1,2]", `"str"`, `{"a":1} trailing`, `{"a":`} {
+		if _, ok := ParseJSON([]byte(line)); ok {
+			t.Fatalf("ParseJSON(%q) accepted", line)
+		}
+	}
+
+	m, ok := ParseJSON([]byte(` {"msg":"hi","n":3,"f":1.5,"ok":true,"nil":null,"obj":{"k":"v"},"arr":[1,"x"]}`))
+	if !ok {
+		t.Fatalf("valid object rejected")
+	}
+	v := MapValue(m)
+	if v.Kind() != olog.KindMap {
+		t.Fatalf("kind=%v", v.Kind())
+	}
+	got := map[string]olog.Value{}
+	for _, kv := range v.AsMap() {
+		got[kv.Key] = kv.Value
+	}
+	if got["msg"].AsString() != "hi" || got["n"].AsInt64() != 3 || got["f"].AsFloat64() != 1.5 || !got["ok"].AsBool() {
+		t.Fatalf("scalars=%v", got)
+	}
+	if !got["nil"].Empty() {
+		t.Fatalf("null=%v", got["nil"])
+	}
+	obj := got["obj"].AsMap()
+	if len(obj) != 1 || obj[0].Key != "k" || obj[0].Value.AsString() != "v" {
+		t.Fatalf("obj=%v", obj)
+	}
+	arr := got["arr"].AsSlice()
+	if len(arr) != 2 || arr[0].AsInt64() != 1 || arr[1].AsString() != "x" {
+		t.Fatalf("arr=%v", arr)
+	}
+}
@@ -0,0 +1,67 @@
+package otelx
+
+import (
+	"strconv"
+	"strings"
+)
+
+// ParseLogfmt decodes a logfmt line such as `level=info msg="started server" dur=12ms`
+// into its pairs. Values stay strings; quoted values may contain spaces and Go escape
+// sequences (\", \\, \n, \t, \uXXXX). It reports false unless the whole line is
+// key=value pairs, so plain text falls back to a string body.
+func ParseLogfmt(line []byte) (map[string]any, bool) {
+	s := strings.TrimSpace(string(line))
+	if s == "" {
+		return nil, false
+	}
+	fields := map[string]any{}
+	for s != "" {
+		eq := strings.IndexAny(s, "= \t\"")
+		if eq <= 0 || s[eq] != '=' {
+			return nil, false
+		}
+		key := s[:eq]
+		s = s[eq+1:]
+
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			end := closingQuote(s)
+			if end < 0 {
+				return nil, false
+			}
+			v, err := strconv.Unquote(s[:end+1])
+			if err != nil {
+				return nil, false
+			}
+			value, s = v, s[end+1:]
+			if s != "" && s[0] != ' ' && s[0] != '\t' {
+				return nil, false
+			}
+		} else {
+			end := strings.IndexAny(s, " \t")
+			if end < 0 {
+				end = len(s)
+			}
+			value, s = s[:end], s[end:]
+			if strings.ContainsAny(value, `="`) {
+				return nil, false
+			}
+		}
+		fields[key] = value
+		s = strings.TrimLeft(s, " \t")
+	}
+	return fields, true
+}
+
+// closingQuote returns the index of the quote ending the string s starts with, or -1.
+func closingQuote(s string) int {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,30 @@
+package otelx
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseLogfmt(t *testing.T) {
+	for _, line := range []string{"", "plain text", "started server port=8080", `msg="unterminated`, `k="v"x`, "=v", `k=a"b`} {
+		if _, ok := ParseLogfmt([]byte(line)); ok {
+			t.Fatalf("ParseLogfmt(%q) accepted", line)
+		}
+	}
+
+	m, ok := ParseLogfmt([]byte(` level=info msg="started \"api\"\tserver" dur=12ms empty= path="C:\\tmp" at=2024-05-01T12:00:00Z`))
+	if !ok {
+		t.Fatalf("valid line rejected")
+	}
+	want := map[string]any{
+		"level": "info",
+		"msg":   "started \"api\"\tserver",
+		"dur":   "12ms",
+		"empty": "",
+		"path":  `C:\tmp`,
+		"at":    "2024-05-01T12:00:00Z",
+	}
+	if !reflect.DeepEqual(m, want) {
+		t.Fatalf("fields=%#v", m)
+	}
+}
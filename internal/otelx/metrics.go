@@ -0,0 +1,320 @@
+package otelx
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
+	logsdk "go.opentelemetry.io/otel/sdk/log"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"google.golang.org/grpc/credentials"
+
+	"github.com/moritzloewenstein/otel-docker-logging-driver/internal/config"
+)
+
+// Reasons a record is dropped, the "reason" attribute of the dropped counter.
+const (
+	DropBufferFull = "buffer_full"
+	DropStopped    = "stopped"
+	// Rejected by the endpoint as invalid while replaying the export queue
+	DropRejected = "rejected"
+	// Evicted from the full export queue before being delivered
+	DropEvicted = "evicted"
+)
+
+// NewMeterProvider creates the provider of the driver's own metrics. Unless
+// cfg.MetricsExporter is "none" they are exported over OTLP to the logs collector,
+// with the same protocol, headers, compression and TLS settings; additional readers,
+// such as a scrape endpoint, can be passed in.
+func NewMeterProvider(ctx context.Context, cfg config.Config, readers ...sdkmetric.Reader) (*sdkmetric.MeterProvider, error) {
+	opts := []sdkmetric.Option{sdkmetric.WithResource(defaultResource())}
+	for _, r := range readers {
+		opts = append(opts, sdkmetric.WithReader(r))
+	}
+	if cfg.MetricsExporter != "none" {
+		exp, err := newMetricExporter(ctx, cfg)
+		if err != nil {
+			return nil, err
+		}
+		var readerOpts []sdkmetric.PeriodicReaderOption
+		if cfg.MetricsInterval > 0 {
+			readerOpts = append(readerOpts, sdkmetric.WithInterval(cfg.MetricsInterval))
+		}
+		opts = append(opts, sdkmetric.WithReader(sdkmetric.NewPeriodicReader(exp, readerOpts...)))
+	}
+	return sdkmetric.NewMeterProvider(opts...), nil
+}
+
+func newMetricExporter(ctx context.Context, cfg config.Config) (sdkmetric.Exporter, error) {
+	certs, err := newCertReloader(cfg.Certificate, cfg.ClientCertificate, cfg.ClientKey, certPollInterval)
+	if err != nil {
+		return nil, fmt.Errorf("load TLS files: %w", err)
+	}
+	var tlsCfg *tls.Config
+	if certs != nil {
+		tlsCfg = certs.tlsConfig(cfg.Endpoint)
+	}
+	gzip := strings.EqualFold(cfg.Compression, "gzip")
+
+	var exp sdkmetric.Exporter
+	if cfg.Protocol == "http" {
+		opts := []otlpmetrichttp.Option{}
+		if u, err := url.Parse(cfg.Endpoint); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+			// Metrics go to the collector's metrics path next to the logs one
+			u.Path = strings.TrimSuffix(strings.TrimSuffix(u.Path, "/"), "/v1/logs") + "/v1/metrics"
+			opts = append(opts, otlpmetrichttp.WithEndpointURL(u.String()))
+		} else if cfg.Endpoint != "" {
+			opts = append(opts, otlpmetrichttp.WithEndpoint(cfg.Endpoint))
+		}
+		if cfg.Insecure {
+			opts = append(opts, otlpmetrichttp.WithInsecure())
+		}
+		if len(cfg.Headers) > 0 {
+			opts = append(opts, otlpmetrichttp.WithHeaders(cfg.Headers))
+		}
+		if gzip {
+			opts = append(opts, otlpmetrichttp.WithCompression(otlpmetrichttp.GzipCompression))
+		}
+		if cfg.Timeout > 0 {
+			opts = append(opts, otlpmetrichttp.WithTimeout(cfg.Timeout))
+		}
+		if tlsCfg != nil {
+			opts = append(opts, otlpmetrichttp.WithTLSClientConfig(tlsCfg))
+		}
+		exp, err = otlpmetrichttp.New(ctx, opts...)
+		if err != nil {
+			certs.Close()
+			return nil, fmt.Errorf("create otlp http metrics exporter: %w", err)
+		}
+	} else {
+		opts := []otlpmetricgrpc.Option{}
+		if u, err := url.Parse(cfg.Endpoint); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+			opts = append(opts, otlpmetricgrpc.WithEndpointURL(cfg.Endpoint))
+		} else if cfg.Endpoint != "" {
+			opts = append(opts, otlpmetricgrpc.WithEndpoint(cfg.Endpoint))
+		}
+		if cfg.Insecure {
+			opts = append(opts, otlpmetricgrpc.WithInsecure())
+		}
+		if len(cfg.Headers) > 0 {
+			opts = append(opts, otlpmetricgrpc.WithHeaders(cfg.Headers))
+		}
+		if gzip {
+			opts = append(opts, otlpmetricgrpc.WithCompressor("gzip"))
+		}
+		if cfg.Timeout > 0 {
+			opts = append(opts, otlpmetricgrpc.WithTimeout(cfg.Timeout))
+		}
+		if tlsCfg != nil {
+			opts = append(opts, otlpmetricgrpc.WithTLSCredentials(credentials.NewTLS(tlsCfg)))
+		}
+		exp, err = otlpmetricgrpc.New(ctx, opts...)
+		if err != nil {
+			certs.Close()
+			return nil, fmt.Errorf("create otlp grpc metrics exporter: %w", err)
+		}
+	}
+	if certs != nil {
+		return &certMetricExporter{Exporter: exp, certs: certs}, nil
+	}
+	return exp, nil
+}
+
+// certMetricExporter stops watching the TLS files when the exporter is shut down.
+type certMetricExporter struct {
+	sdkmetric.Exporter
+	certs *certReloader
+}
+
+func (e *certMetricExporter) Shutdown(ctx context.Context) error {
+	e.certs.Close()
+	return e.Exporter.Shutdown(ctx)
+}
+
+// Metrics are the instruments of the driver's pipeline. Per-container instruments
+// carry the container.id and container.name attributes.
+type Metrics struct {
+	meter metric.Meter
+
+	// Complete lines read from a container's FIFO
+	Received metric.Int64Counter
+	// Records handed to the logs SDK
+	Emitted metric.Int64Counter
+	// Records discarded, by reason
+	Dropped metric.Int64Counter
+	// Undecodable FIFO frames
+	DecodeErrors metric.Int64Counter
+	// Containers whose FIFO is being consumed
+	ActiveContainers metric.Int64UpDownCounter
+	// Records waiting in a container's buffer
+	BufferDepth metric.Int64ObservableGauge
+	// Duration and size of export requests, by endpoint and outcome
+	ExportDuration  metric.Float64Histogram
+	ExportedRecords metric.Int64Counter
+
+	// Unix nanoseconds of the last successful export and of the first failure since
+	lastSuccess  atomic.Int64
+	failingSince atomic.Int64
+
+	mu        sync.Mutex
+	endpoints map[string]EndpointState
+}
+
+// EndpointState is the outcome of the latest export requests to one endpoint.
+type EndpointState struct {
+	LastSuccess time.Time `json:"last_success,omitzero"`
+	LastError   time.Time `json:"last_error,omitzero"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// NewMetrics creates the instruments from mp.
+func NewMetrics(mp metric.MeterProvider) (*Metrics, error) {
+	m := &Metrics{meter: mp.Meter(scopeName), endpoints: map[string]EndpointState{}}
+	var errs []error
+	add := func(err error) {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	var err error
+	m.Received, err = m.meter.Int64Counter("logdriver.records.received", metric.WithUnit("{record}"),
+		metric.WithDescription("Log lines read from container FIFOs."))
+	add(err)
+	m.Emitted, err = m.meter.Int64Counter("logdriver.records.emitted", metric.WithUnit("{record}"),
+		metric.WithDescription("Log records handed to the OpenTelemetry logs SDK."))
+	add(err)
+	m.Dropped, err = m.meter.Int64Counter("logdriver.records.dropped", metric.WithUnit("{record}"),
+		metric.WithDescription("Log records discarded instead of being exported."))
+	add(err)
+	m.DecodeErrors, err = m.meter.Int64Counter("logdriver.decode.errors", metric.WithUnit("{error}"),
+		metric.WithDescription("Frames read from container FIFOs that could not be decoded."))
+	add(err)
+	m.ActiveContainers, err = m.meter.Int64UpDownCounter("logdriver.containers.active", metric.WithUnit("{container}"),
+		metric.WithDescription("Containers whose logs are being consumed."))
+	add(err)
+	m.BufferDepth, err = m.meter.Int64ObservableGauge("logdriver.buffer.depth", metric.WithUnit("{record}"),
+		metric.WithDescription("Log records waiting in a container's buffer."))
+	add(err)
+	m.ExportDuration, err = m.meter.Float64Histogram("logdriver.export.duration", metric.WithUnit("s"),
+		metric.WithDescription("Duration of log export requests."))
+	add(err)
+	m.ExportedRecords, err = m.meter.Int64Counter("logdriver.export.records", metric.WithUnit("{record}"),
+		metric.WithDescription("Log records passed to export requests, by outcome."))
+	add(err)
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("create metrics: %w", errors.Join(errs...))
+	}
+	return m, nil
+}
+
+// NoopMetrics returns instruments that record nothing.
+func NoopMetrics() *Metrics {
+	m, _ := NewMetrics(noop.NewMeterProvider())
+	return m
+}
+
+// ContainerAttributes identifies a container on per-container instruments.
+func ContainerAttributes(id, name string) metric.MeasurementOption {
+	return metric.WithAttributeSet(attribute.NewSet(
+		attribute.String("container.id", id),
+		attribute.String("container.name", strings.TrimPrefix(name, "/")),
+	))
+}
+
+// ObserveBufferDepth registers a callback reporting buffer depths; observe is called
+// with a report function for each container.
+func (m *Metrics) ObserveBufferDepth(observe func(report func(id, name string, depth int64))) (metric.Registration, error) {
+	return m.meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		observe(func(id, name string, depth int64) {
+			o.ObserveInt64(m.BufferDepth, depth, ContainerAttributes(id, name))
+		})
+		return nil
+	}, m.BufferDepth)
+}
+
+// ExportState returns when an export request last succeeded and since when requests
+// have been failing without a success in between. Either is zero if there is none.
+func (m *Metrics) ExportState() (lastSuccess, failingSince time.Time) {
+	unix := func(n int64) time.Time {
+		if n == 0 {
+			return time.Time{}
+		}
+		return time.Unix(0, n)
+	}
+	return unix(m.lastSuccess.Load()), unix(m.failingSince.Load())
+}
+
+// EndpointState returns the outcome of the latest export requests to endpoint; it is
+// zero until an exporter created by NewExporter sent a request there.
+func (m *Metrics) EndpointState(endpoint string) EndpointState {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.endpoints[endpoint]
+}
+
+// NewExporter is the ExporterFactory NewExporter recording the duration and outcome
+// of the requests sent to the endpoint, behind the on-disk queue if there is one.
+func (m *Metrics) NewExporter(ctx context.Context, cfg config.Config) (Exporter, error) {
+	return newExporter(ctx, cfg, func(exp Exporter) Exporter {
+		return &instrumentedExporter{Exporter: exp, m: m, endpoint: cfg.Endpoint}
+	}, func(reason string, n int) {
+		m.Dropped.Add(context.Background(), int64(n), metric.WithAttributes(
+			attribute.String("reason", reason),
+			attribute.String("server.address", cfg.Endpoint),
+		))
+	})
+}
+
+type instrumentedExporter struct {
+	Exporter
+	m        *Metrics
+	endpoint string
+}
+
+func (e *instrumentedExporter) Export(ctx context.Context, recs []logsdk.Record) error {
+	start := time.Now()
+	err := e.Exporter.Export(ctx, recs)
+	e.m.recordOutcome(e.endpoint, err)
+	outcome := "success"
+	if err != nil {
+		outcome = "failure"
+	}
+	attrs := metric.WithAttributeSet(attribute.NewSet(
+		attribute.String("server.address", e.endpoint),
+		attribute.String("outcome", outcome),
+	))
+	e.m.ExportDuration.Record(ctx, time.Since(start).Seconds(), attrs)
+	e.m.ExportedRecords.Add(ctx, int64(len(recs)), attrs)
+	return err
+}
+
+func (m *Metrics) recordOutcome(endpoint string, err error) {
+	now := time.Now()
+	m.mu.Lock()
+	state := m.endpoints[endpoint]
+	if err != nil {
+		state.LastError, state.Error = now, err.Error()
+	} else {
+		state.LastSuccess = now
+	}
+	m.endpoints[endpoint] = state
+	m.mu.Unlock()
+
+	if err != nil {
+		m.failingSince.CompareAndSwap(0, now.UnixNano())
+	} else {
+		m.lastSuccess.Store(now.UnixNano())
+		m.failingSince.Store(0)
+	}
+}
@@ -0,0 +1,80 @@
+package otelx
+
+import (
+	"context"
+	"testing"
+
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+
+	"github.com/moritzloewenstein/otel-docker-logging-driver/internal/config"
+)
+
+func TestMetrics_InstrumentedExporter(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	mp, err := NewMeterProvider(context.Background(), config.Config{MetricsExporter: "none"}, reader)
+	if err != nil {
+		t.Fatalf("meter provider: %v", err)
+	}
+	m, err := NewMetrics(mp)
+	if err != nil {
+		t.Fatalf("metrics: %v", err)
+	}
+	ok := &instrumentedExporter{Exporter: &flakyExporter{}, m: m, endpoint: "a:4317"}
+	failing := &instrumentedExporter{Exporter: &flakyExporter{failures: 1}, m: m, endpoint: "b:4317"}
+	_ = ok.Export(context.Background(), emitRecords(t, "x", "y"))
+	_ = failing.Export(context.Background(), emitRecords(t, "z"))
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatalf("collect: %v", err)
+	}
+	records := map[string]int64{}
+	var durations uint64
+	for _, sm := range rm.ScopeMetrics {
+		for _, metric := range sm.Metrics {
+			switch data := metric.Data.(type) {
+			case metricdata.Sum[int64]:
+				for _, dp := range data.DataPoints {
+					outcome, _ := dp.Attributes.Value("outcome")
+					records[outcome.AsString()] += dp.Value
+				}
+			case metricdata.Histogram[float64]:
+				for _, dp := range data.DataPoints {
+					durations += dp.Count
+				}
+			}
+		}
+	}
+	if records["success"] != 2 || records["failure"] != 1 || durations != 2 {
+		t.Fatalf("records=%v durations=%d", records, durations)
+	}
+}
+
+func TestMetrics_ExportState(t *testing.T) {
+	m := NoopMetrics()
+	if ok, failing := m.ExportState(); !ok.IsZero() || !failing.IsZero() {
+		t.Fatalf("initial state: %v %v", ok, failing)
+	}
+	exp := &instrumentedExporter{Exporter: &flakyExporter{failures: 2}, m: m, endpoint: "a:4317"}
+
+	_ = exp.Export(context.Background(), emitRecords(t, "x"))
+	_, first := m.ExportState()
+	_ = exp.Export(context.Background(), emitRecords(t, "x"))
+	if ok, failing := m.ExportState(); !ok.IsZero() || failing.IsZero() || !failing.Equal(first) {
+		t.Fatalf("after failures: %v %v (first %v)", ok, failing, first)
+	}
+
+	_ = exp.Export(context.Background(), emitRecords(t, "x"))
+	if ok, failing := m.ExportState(); ok.IsZero() || !failing.IsZero() {
+		t.Fatalf("after success: %v %v", ok, failing)
+	}
+
+	st := m.EndpointState("a:4317")
+	if st.Error != "collector unavailable" || st.LastError.IsZero() || st.LastSuccess.Before(st.LastError) {
+		t.Fatalf("endpoint state=%+v", st)
+	}
+	if st := m.EndpointState("b:4317"); st != (EndpointState{}) {
+		t.Fatalf("unused endpoint state=%+v", st)
+	}
+}
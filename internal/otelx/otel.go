@@ -3,7 +3,6 @@ package otelx
 import (
 	"context"
 	"crypto/tls"
-	"crypto/x509"
 	"fmt"
 	"net/url"
 	"os"
@@ -14,7 +13,6 @@ import (
 	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploggrpc"
 	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploghttp"
 	olog "go.opentelemetry.io/otel/log"
-	"go.opentelemetry.io/otel/log/global"
 	logsdk "go.opentelemetry.io/otel/sdk/log"
 	"go.opentelemetry.io/otel/sdk/resource"
 	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
@@ -27,15 +25,58 @@ type Exporter = logsdk.Exporter
 
 type Provider = logsdk.LoggerProvider
 
-func SetupProvider(ctx context.Context, cfg config.Config) (Exporter, *Provider, error) {
+// ExporterFactory creates the exporter for an effective exporter config.
+type ExporterFactory func(context.Context, config.Config) (Exporter, error)
+
+// ProcessorFactory wraps an exporter into the processor records are emitted through.
+type ProcessorFactory func(config.Config, Exporter) logsdk.Processor
+
+// NewExporter creates the OTLP logs exporter for cfg, backed by the on-disk queue when
+// cfg.QueueDir is set.
+func NewExporter(ctx context.Context, cfg config.Config) (Exporter, error) {
+	return newExporter(ctx, cfg, nil, nil)
+}
+
+// newExporter is NewExporter with an optional wrapper around the OTLP exporter, and
+// an optional callback counting the records of batches the on-disk queue drops, by
+// reason: evicted when full or rejected by the endpoint.
+func newExporter(ctx context.Context, cfg config.Config, wrap func(Exporter) Exporter, dropped func(reason string, n int)) (Exporter, error) {
+	exp, err := newOTLPExporter(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if wrap != nil {
+		exp = wrap(exp)
+	}
+	if cfg.QueueDir == "" {
+		return exp, nil
+	}
+	q, err := newQueueExporter(exp, cfg, dropped)
+	if err != nil {
+		_ = exp.Shutdown(ctx)
+		return nil, err
+	}
+	return q, nil
+}
+
+func newOTLPExporter(ctx context.Context, cfg config.Config) (Exporter, error) {
 	protocol := cfg.Protocol
 	if protocol == "" {
 		// Backwards-compatible default is gRPC, even if endpoint has http(s) scheme
 		protocol = "grpc"
 	}
 
+	certs, err := newCertReloader(cfg.Certificate, cfg.ClientCertificate, cfg.ClientKey, certPollInterval)
+	if err != nil {
+		return nil, fmt.Errorf("load TLS files: %w", err)
+	}
+	var tlsCfg *tls.Config
+	if certs != nil {
+		tlsCfg = certs.tlsConfig(cfg.Endpoint)
+	}
+	gzip := strings.EqualFold(cfg.Compression, "gzip")
+
 	var exp Exporter
-	var err error
 
 	switch strings.ToLower(protocol) {
 	case "http":
@@ -60,10 +101,25 @@ func SetupProvider(ctx context.Context, cfg config.Config) (Exporter, *Provider,
 		if len(cfg.Headers) > 0 {
 			opts = append(opts, otlploghttp.WithHeaders(cfg.Headers))
 		}
-		// Note: compression and file-based TLS are currently applied only for gRPC
+		// Compression
+		if gzip {
+			opts = append(opts, otlploghttp.WithCompression(otlploghttp.GzipCompression))
+		}
+		// Timeout and retry
+		if cfg.Timeout > 0 {
+			opts = append(opts, otlploghttp.WithTimeout(cfg.Timeout))
+		}
+		if cfg.RetryInitialInterval > 0 {
+			opts = append(opts, otlploghttp.WithRetry(otlploghttp.RetryConfig(retryConfig(cfg))))
+		}
+		// Optional TLS via files
+		if tlsCfg != nil {
+			opts = append(opts, otlploghttp.WithTLSClientConfig(tlsCfg))
+		}
 		exp, err = otlploghttp.New(ctx, opts...)
 		if err != nil {
-			return nil, nil, fmt.Errorf("create otlp http logs exporter: %w", err)
+			certs.Close()
+			return nil, fmt.Errorf("create otlp http logs exporter: %w", err)
 		}
 	default: // grpc
 		opts := []otlploggrpc.Option{}
@@ -84,77 +140,95 @@ func SetupProvider(ctx context.Context, cfg config.Config) (Exporter, *Provider,
 			opts = append(opts, otlploggrpc.WithHeaders(cfg.Headers))
 		}
 		// Compression
-		switch cfg.Compression {
-		case "gzip", "GZIP":
+		if gzip {
 			opts = append(opts, otlploggrpc.WithCompressor("gzip"))
 		}
-		// Optional TLS via files (env-based): prefer LOGS_* vars, fall back to generic OTLP_* vars
-		ca := os.Getenv("OTEL_EXPORTER_OTLP_LOGS_CERTIFICATE")
-		if ca == "" {
-			ca = os.Getenv("OTEL_EXPORTER_OTLP_CERTIFICATE")
+		// Timeout and retry
+		if cfg.Timeout > 0 {
+			opts = append(opts, otlploggrpc.WithTimeout(cfg.Timeout))
 		}
-		if ca != "" {
-			clientCert := os.Getenv("OTEL_EXPORTER_OTLP_LOGS_CLIENT_CERTIFICATE")
-			if clientCert == "" {
-				clientCert = os.Getenv("OTEL_EXPORTER_OTLP_CLIENT_CERTIFICATE")
-			}
-			clientKey := os.Getenv("OTEL_EXPORTER_OTLP_LOGS_CLIENT_KEY")
-			if clientKey == "" {
-				clientKey = os.Getenv("OTEL_EXPORTER_OTLP_CLIENT_KEY")
-			}
-			creds, lerr := loadTLSCreds(ca, clientCert, clientKey)
-			if lerr == nil {
-				opts = append(opts, otlploggrpc.WithTLSCredentials(creds))
-			}
+		if cfg.RetryInitialInterval > 0 {
+			opts = append(opts, otlploggrpc.WithRetry(otlploggrpc.RetryConfig(retryConfig(cfg))))
+		}
+		// Optional TLS via files
+		if tlsCfg != nil {
+			opts = append(opts, otlploggrpc.WithTLSCredentials(credentials.NewTLS(tlsCfg)))
 		}
 		exp, err = otlploggrpc.New(ctx, opts...)
 		if err != nil {
-			return nil, nil, fmt.Errorf("create otlp grpc logs exporter: %w", err)
+			certs.Close()
+			return nil, fmt.Errorf("create otlp grpc logs exporter: %w", err)
 		}
 	}
 
-	proc := logsdk.NewBatchProcessor(exp)
-	res, _ := resource.Merge(resource.Default(), resource.NewWithAttributes(
-		semconv.SchemaURL,
-		semconv.ServiceName("otel-docker-logging-driver"),
-		attribute.String("process.executable.name", os.Args[0]),
-	))
-	provider := logsdk.NewLoggerProvider(
-		logsdk.WithProcessor(proc),
-		logsdk.WithResource(res),
-	)
-	global.SetLoggerProvider(provider)
-	return exp, provider, nil
+	if certs != nil {
+		return &certExporter{Exporter: exp, certs: certs}, nil
+	}
+	return exp, nil
 }
 
-func loadTLSCreds(caFile, certFile, keyFile string) (credentials.TransportCredentials, error) {
-	certPool := x509.NewCertPool()
-	pemServerCA, err := os.ReadFile(caFile)
-	if err != nil {
-		return nil, err
+// certExporter stops watching the TLS files when the exporter is shut down.
+type certExporter struct {
+	Exporter
+	certs *certReloader
+}
+
+func (e *certExporter) Shutdown(ctx context.Context) error {
+	e.certs.Close()
+	return e.Exporter.Shutdown(ctx)
+}
+
+// retryConfig is the retry policy of cfg in the form both OTLP exporters take.
+func retryConfig(cfg config.Config) otlploggrpc.RetryConfig {
+	return otlploggrpc.RetryConfig{
+		Enabled:         cfg.RetryEnabled,
+		InitialInterval: cfg.RetryInitialInterval,
+		MaxInterval:     cfg.RetryMaxInterval,
+		MaxElapsedTime:  cfg.RetryMaxElapsedTime,
 	}
-	if ok := certPool.AppendCertsFromPEM(pemServerCA); !ok {
-		return nil, fmt.Errorf("failed to add server CA")
+}
+
+// NewBatchProcessor is the ProcessorFactory used by the plugin. Unset (zero) settings
+// keep the SDK defaults.
+func NewBatchProcessor(cfg config.Config, exp Exporter) logsdk.Processor {
+	var opts []logsdk.BatchProcessorOption
+	if cfg.BatchMaxQueueSize > 0 {
+		opts = append(opts, logsdk.WithMaxQueueSize(cfg.BatchMaxQueueSize))
 	}
-	var clientCerts []tls.Certificate
-	if certFile != "" && keyFile != "" {
-		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
-		if err != nil {
-			return nil, err
-		}
-		clientCerts = []tls.Certificate{cert}
+	if cfg.BatchScheduleDelay > 0 {
+		opts = append(opts, logsdk.WithExportInterval(cfg.BatchScheduleDelay))
+	}
+	if cfg.BatchMaxExportBatchSize > 0 {
+		opts = append(opts, logsdk.WithExportMaxBatchSize(cfg.BatchMaxExportBatchSize))
+	}
+	if cfg.BatchExportTimeout > 0 {
+		opts = append(opts, logsdk.WithExportTimeout(cfg.BatchExportTimeout))
 	}
-	config := &tls.Config{RootCAs: certPool, Certificates: clientCerts, MinVersion: tls.VersionTLS12}
-	return credentials.NewTLS(config), nil
+	return logsdk.NewBatchProcessor(exp, opts...)
+}
+
+// defaultResource describes the driver itself.
+func defaultResource() *resource.Resource {
+	res, _ := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName("otel-docker-logging-driver"),
+		attribute.String("process.executable.name", os.Args[0]),
+	))
+	return res
 }
 
 // BuildRecord constructs a log record with standard mapping.
 func BuildRecord(ts time.Time, body string, severity olog.Severity, attrs ...olog.KeyValue) olog.Record {
+	return BuildRecordValue(ts, olog.StringValue(body), severity, attrs...)
+}
+
+// BuildRecordValue is BuildRecord for structured bodies.
+func BuildRecordValue(ts time.Time, body olog.Value, severity olog.Severity, attrs ...olog.KeyValue) olog.Record {
 	var rec olog.Record
 	rec.SetTimestamp(ts)
 	rec.SetObservedTimestamp(time.Now())
 	rec.SetSeverity(severity)
-	rec.SetBody(olog.StringValue(body))
+	rec.SetBody(body)
 	rec.AddAttributes(attrs...)
 	return rec
 }
@@ -1,10 +1,26 @@
 package otelx
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	olog "go.opentelemetry.io/otel/log"
+
+	"github.com/moritzloewenstein/otel-docker-logging-driver/internal/config"
 )
 
 func TestBuildRecord(t *testing.T) {
@@ -31,3 +47,73 @@ func TestBuildRecord(t *testing.T) {
 		t.Fatalf("attrs=%v", attrs)
 	}
 }
+
+// writeClientCert writes a self-signed client certificate and its key as PEM files.
+func writeClientCert(t *testing.T, dir, cn string, notAfter time.Time) (certFile, keyFile string, cert *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile = filepath.Join(dir, "client.pem"), filepath.Join(dir, "client-key.pem")
+	_ = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	_ = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	cert, _ = x509.ParseCertificate(der)
+	return certFile, keyFile, cert
+}
+
+func TestNewExporter_HTTPWithMTLSAndGzip(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, clientCert := writeClientCert(t, dir, "driver", time.Now().Add(time.Hour))
+
+	var gotEncoding atomic.Value
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotEncoding.Store(r.Header.Get("Content-Encoding"))
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		w.WriteHeader(http.StatusOK)
+	}))
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	srv.StartTLS()
+	defer srv.Close()
+
+	caFile := filepath.Join(dir, "ca.pem")
+	_ = os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0o600)
+
+	cfg := config.Config{
+		Endpoint:          srv.URL,
+		Protocol:          "http",
+		Compression:       "gzip",
+		Certificate:       caFile,
+		ClientCertificate: certFile,
+		ClientKey:         keyFile,
+	}
+	ctx := context.Background()
+	exp, err := NewExporter(ctx, cfg)
+	if err != nil {
+		t.Fatalf("new exporter: %v", err)
+	}
+	defer func() { _ = exp.Shutdown(ctx) }()
+	if err := exp.Export(ctx, emitRecords(t, "hello")); err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	if got, _ := gotEncoding.Load().(string); got != "gzip" {
+		t.Fatalf("content-encoding=%q", got)
+	}
+}
@@ -0,0 +1,277 @@
+package otelx
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	olog "go.opentelemetry.io/otel/log"
+	logsdk "go.opentelemetry.io/otel/sdk/log"
+	"go.opentelemetry.io/otel/sdk/resource"
+	"go.opentelemetry.io/otel/trace"
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// marshalRecords encodes records as an OTLP ExportLogsServiceRequest, grouped by
+// resource and instrumentation scope.
+func marshalRecords(recs []logsdk.Record) ([]byte, error) {
+	req := &collogspb.ExportLogsServiceRequest{}
+	resIdx := map[*resource.Resource]*logspb.ResourceLogs{}
+	type scopeKey struct {
+		res   *resource.Resource
+		name  string
+		ver   string
+		attrs attribute.Distinct
+	}
+	scopeIdx := map[scopeKey]*logspb.ScopeLogs{}
+
+	for i := range recs {
+		r := &recs[i]
+		res := r.Resource()
+		rl, ok := resIdx[res]
+		if !ok {
+			rl = &logspb.ResourceLogs{Resource: &resourcepb.Resource{}}
+			if res != nil {
+				rl.Resource.Attributes = attrsToPB(res.Attributes())
+				rl.SchemaUrl = res.SchemaURL()
+			}
+			resIdx[res] = rl
+			req.ResourceLogs = append(req.ResourceLogs, rl)
+		}
+		scope := r.InstrumentationScope()
+		sk := scopeKey{res: res, name: scope.Name, ver: scope.Version, attrs: scope.Attributes.Equivalent()}
+		sl, ok := scopeIdx[sk]
+		if !ok {
+			sl = &logspb.ScopeLogs{
+				Scope: &commonpb.InstrumentationScope{
+					Name:       scope.Name,
+					Version:    scope.Version,
+					Attributes: attrsToPB(scope.Attributes.ToSlice()),
+				},
+				SchemaUrl: scope.SchemaURL,
+			}
+			scopeIdx[sk] = sl
+			rl.ScopeLogs = append(rl.ScopeLogs, sl)
+		}
+		sl.LogRecords = append(sl.LogRecords, recordToPB(r))
+	}
+	return proto.Marshal(req)
+}
+
+func recordToPB(r *logsdk.Record) *logspb.LogRecord {
+	lr := &logspb.LogRecord{
+		TimeUnixNano:           unixNano(r.Timestamp()),
+		ObservedTimeUnixNano:   unixNano(r.ObservedTimestamp()),
+		SeverityNumber:         logspb.SeverityNumber(r.Severity()),
+		SeverityText:           r.SeverityText(),
+		Body:                   valueToPB(r.Body()),
+		DroppedAttributesCount: uint32(r.DroppedAttributes()),
+		Flags:                  uint32(r.TraceFlags()),
+		EventName:              r.EventName(),
+	}
+	r.WalkAttributes(func(kv olog.KeyValue) bool {
+		lr.Attributes = append(lr.Attributes, &commonpb.KeyValue{Key: kv.Key, Value: valueToPB(kv.Value)})
+		return true
+	})
+	if tid := r.TraceID(); tid.IsValid() {
+		lr.TraceId = tid[:]
+	}
+	if sid := r.SpanID(); sid.IsValid() {
+		lr.SpanId = sid[:]
+	}
+	return lr
+}
+
+func unixNano(t time.Time) uint64 {
+	if t.IsZero() {
+		return 0
+	}
+	return uint64(t.UnixNano())
+}
+
+func valueToPB(v olog.Value) *commonpb.AnyValue {
+	switch v.Kind() {
+	case olog.KindBool:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_BoolValue{BoolValue: v.AsBool()}}
+	case olog.KindInt64:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: v.AsInt64()}}
+	case olog.KindFloat64:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_DoubleValue{DoubleValue: v.AsFloat64()}}
+	case olog.KindString:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: v.AsString()}}
+	case olog.KindBytes:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_BytesValue{BytesValue: v.AsBytes()}}
+	case olog.KindSlice:
+		arr := &commonpb.ArrayValue{}
+		for _, e := range v.AsSlice() {
+			arr.Values = append(arr.Values, valueToPB(e))
+		}
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_ArrayValue{ArrayValue: arr}}
+	case olog.KindMap:
+		kvl := &commonpb.KeyValueList{}
+		for _, kv := range v.AsMap() {
+			kvl.Values = append(kvl.Values, &commonpb.KeyValue{Key: kv.Key, Value: valueToPB(kv.Value)})
+		}
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_KvlistValue{KvlistValue: kvl}}
+	}
+	return nil
+}
+
+func attrsToPB(attrs []attribute.KeyValue) []*commonpb.KeyValue {
+	out := make([]*commonpb.KeyValue, 0, len(attrs))
+	for _, kv := range attrs {
+		var v *commonpb.AnyValue
+		switch kv.Value.Type() {
+		case attribute.BOOL:
+			v = valueToPB(olog.BoolValue(kv.Value.AsBool()))
+		case attribute.INT64:
+			v = valueToPB(olog.Int64Value(kv.Value.AsInt64()))
+		case attribute.FLOAT64:
+			v = valueToPB(olog.Float64Value(kv.Value.AsFloat64()))
+		case attribute.STRINGSLICE:
+			vals := make([]olog.Value, 0, len(kv.Value.AsStringSlice()))
+			for _, s := range kv.Value.AsStringSlice() {
+				vals = append(vals, olog.StringValue(s))
+			}
+			v = valueToPB(olog.SliceValue(vals...))
+		default:
+			v = valueToPB(olog.StringValue(kv.Value.Emit()))
+		}
+		out = append(out, &commonpb.KeyValue{Key: string(kv.Key), Value: v})
+	}
+	return out
+}
+
+// unmarshalRecords decodes a request written by marshalRecords back into SDK records.
+// Resource and scope cannot be set on a Record directly, so a template record is
+// obtained per resource/scope pair by emitting through a throwaway provider.
+func unmarshalRecords(b []byte) ([]logsdk.Record, error) {
+	var req collogspb.ExportLogsServiceRequest
+	if err := proto.Unmarshal(b, &req); err != nil {
+		return nil, fmt.Errorf("decode queued logs: %w", err)
+	}
+	var out []logsdk.Record
+	for _, rl := range req.ResourceLogs {
+		res := resource.NewWithAttributes(rl.SchemaUrl, attrsFromPB(rl.GetResource().GetAttributes())...)
+		capture := &captureProcessor{}
+		provider := logsdk.NewLoggerProvider(
+			logsdk.WithProcessor(capture),
+			logsdk.WithResource(res),
+			logsdk.WithAttributeCountLimit(-1),
+			logsdk.WithAttributeValueLengthLimit(-1),
+		)
+		for _, sl := range rl.ScopeLogs {
+			scope := sl.GetScope()
+			logger := provider.Logger(scope.GetName(),
+				olog.WithInstrumentationVersion(scope.GetVersion()),
+				olog.WithSchemaURL(sl.SchemaUrl),
+				olog.WithInstrumentationAttributes(attrsFromPB(scope.GetAttributes())...),
+			)
+			capture.recs = capture.recs[:0]
+			logger.Emit(context.Background(), olog.Record{})
+			if len(capture.recs) != 1 {
+				return nil, fmt.Errorf("decode queued logs: no template record")
+			}
+			tmpl := capture.recs[0]
+			for _, lr := range sl.LogRecords {
+				out = append(out, recordFromPB(tmpl, lr))
+			}
+		}
+	}
+	return out, nil
+}
+
+func recordFromPB(tmpl logsdk.Record, lr *logspb.LogRecord) logsdk.Record {
+	r := tmpl.Clone()
+	if lr.TimeUnixNano != 0 {
+		r.SetTimestamp(time.Unix(0, int64(lr.TimeUnixNano)))
+	}
+	r.SetObservedTimestamp(time.Unix(0, int64(lr.ObservedTimeUnixNano)))
+	r.SetSeverity(olog.Severity(lr.SeverityNumber))
+	r.SetSeverityText(lr.SeverityText)
+	r.SetBody(valueFromPB(lr.Body))
+	r.SetEventName(lr.EventName)
+	attrs := make([]olog.KeyValue, len(lr.Attributes))
+	for i, kv := range lr.Attributes {
+		attrs[i] = olog.KeyValue{Key: kv.Key, Value: valueFromPB(kv.Value)}
+	}
+	r.SetAttributes(attrs...)
+	var tid trace.TraceID
+	copy(tid[:], lr.TraceId)
+	r.SetTraceID(tid)
+	var sid trace.SpanID
+	copy(sid[:], lr.SpanId)
+	r.SetSpanID(sid)
+	r.SetTraceFlags(trace.TraceFlags(lr.Flags))
+	return r
+}
+
+func valueFromPB(v *commonpb.AnyValue) olog.Value {
+	switch t := v.GetValue().(type) {
+	case *commonpb.AnyValue_BoolValue:
+		return olog.BoolValue(t.BoolValue)
+	case *commonpb.AnyValue_IntValue:
+		return olog.Int64Value(t.IntValue)
+	case *commonpb.AnyValue_DoubleValue:
+		return olog.Float64Value(t.DoubleValue)
+	case *commonpb.AnyValue_StringValue:
+		return olog.StringValue(t.StringValue)
+	case *commonpb.AnyValue_BytesValue:
+		return olog.BytesValue(t.BytesValue)
+	case *commonpb.AnyValue_ArrayValue:
+		vals := make([]olog.Value, len(t.ArrayValue.GetValues()))
+		for i, e := range t.ArrayValue.GetValues() {
+			vals[i] = valueFromPB(e)
+		}
+		return olog.SliceValue(vals...)
+	case *commonpb.AnyValue_KvlistValue:
+		kvs := make([]olog.KeyValue, len(t.KvlistValue.GetValues()))
+		for i, kv := range t.KvlistValue.GetValues() {
+			kvs[i] = olog.KeyValue{Key: kv.Key, Value: valueFromPB(kv.Value)}
+		}
+		return olog.MapValue(kvs...)
+	}
+	return olog.Value{}
+}
+
+func attrsFromPB(kvs []*commonpb.KeyValue) []attribute.KeyValue {
+	out := make([]attribute.KeyValue, 0, len(kvs))
+	for _, kv := range kvs {
+		k := attribute.Key(kv.Key)
+		switch t := kv.GetValue().GetValue().(type) {
+		case *commonpb.AnyValue_BoolValue:
+			out = append(out, k.Bool(t.BoolValue))
+		case *commonpb.AnyValue_IntValue:
+			out = append(out, k.Int64(t.IntValue))
+		case *commonpb.AnyValue_DoubleValue:
+			out = append(out, k.Float64(t.DoubleValue))
+		case *commonpb.AnyValue_ArrayValue:
+			var ss []string
+			for _, e := range t.ArrayValue.GetValues() {
+				ss = append(ss, e.GetStringValue())
+			}
+			out = append(out, k.StringSlice(ss))
+		default:
+			out = append(out, k.String(kv.GetValue().GetStringValue()))
+		}
+	}
+	return out
+}
+
+// captureProcessor keeps the records emitted through it.
+type captureProcessor struct {
+	recs []logsdk.Record
+}
+
+func (c *captureProcessor) OnEmit(_ context.Context, r *logsdk.Record) error {
+	c.recs = append(c.recs, r.Clone())
+	return nil
+}
+
+func (c *captureProcessor) Shutdown(context.Context) error   { return nil }
+func (c *captureProcessor) ForceFlush(context.Context) error { return nil }
@@ -0,0 +1,150 @@
+package otelx
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Pattern extracts named fields from a text line: a regular expression with named
+// capture groups, or a Grok expression compiled into one.
+type Pattern struct {
+	expr string
+	re   *regexp.Regexp
+	// Field of each subexpression; empty for unnamed groups
+	fields []patternField
+}
+
+type patternField struct {
+	name string
+	// Conversion of the captured text: "", "int" or "float"
+	typ string
+}
+
+// CompileRegex compiles a regular expression (RE2 syntax) whose named capture groups,
+// (?P<name>...), become the fields.
+func CompileRegex(expr string) (*Pattern, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &Pattern{expr: expr, re: re, fields: make([]patternField, re.NumSubexp()+1)}
+	for i, name := range re.SubexpNames() {
+		p.fields[i].name = name
+	}
+	if !p.hasFields() {
+		return nil, fmt.Errorf("pattern %q has no named capture groups", expr)
+	}
+	return p, nil
+}
+
+// grokRef matches %{NAME}, %{NAME:field} and %{NAME:field:type}.
+var grokRef = regexp.MustCompile(`%\{(\w+)(?::([\w.@\[\]-]+))?(?::(int|float))?\}`)
+
+// maxGrokDepth bounds the expansion of patterns referring to patterns.
+const maxGrokDepth = 16
+
+// CompileGrok compiles a Grok expression such as `%{IP:client} %{LOGLEVEL:level}
+// %{GREEDYDATA:message}` using the bundled patterns. A reference with a field name
+// captures into that field, optionally converted by an int or float suffix.
+// Named groups written as (?P<name>...) are fields as well.
+func CompileGrok(expr string) (*Pattern, error) {
+	fields := map[string]patternField{}
+	var expand func(s string, depth int) (string, error)
+	expand = func(s string, depth int) (string, error) {
+		if depth > maxGrokDepth {
+			return "", fmt.Errorf("grok patterns nested too deeply in %q", expr)
+		}
+		var err error
+		out := grokRef.ReplaceAllStringFunc(s, func(ref string) string {
+			m := grokRef.FindStringSubmatch(ref)
+			def, ok := grokPatterns[m[1]]
+			if !ok {
+				if err == nil {
+					err = fmt.Errorf("unknown grok pattern %q", m[1])
+				}
+				return ""
+			}
+			sub, e := expand(def, depth+1)
+			if e != nil {
+				if err == nil {
+					err = e
+				}
+				return ""
+			}
+			if m[2] == "" {
+				return "(?:" + sub + ")"
+			}
+			group := "grok" + strconv.Itoa(len(fields))
+			fields[group] = patternField{name: m[2], typ: m[3]}
+			return "(?P<" + group + ">" + sub + ")"
+		})
+		return out, err
+	}
+	src, err := expand(expr, 0)
+	if err != nil {
+		return nil, err
+	}
+	re, err := regexp.Compile(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &Pattern{expr: expr, re: re, fields: make([]patternField, re.NumSubexp()+1)}
+	for i, name := range re.SubexpNames() {
+		if f, ok := fields[name]; ok {
+			p.fields[i] = f
+		} else {
+			p.fields[i].name = name
+		}
+	}
+	if !p.hasFields() {
+		return nil, fmt.Errorf("grok expression %q captures no fields", expr)
+	}
+	return p, nil
+}
+
+func (p *Pattern) hasFields() bool {
+	for _, f := range p.fields {
+		if f.name != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Match returns the fields captured from line. Groups that did not participate in the
+// match are left out; values that fail their int or float conversion stay strings.
+// It reports false if the line does not match.
+func (p *Pattern) Match(line string) (map[string]any, bool) {
+	idx := p.re.FindStringSubmatchIndex(line)
+	if idx == nil {
+		return nil, false
+	}
+	out := map[string]any{}
+	for i, f := range p.fields {
+		if f.name == "" || idx[2*i] < 0 {
+			continue
+		}
+		v := line[idx[2*i]:idx[2*i+1]]
+		switch f.typ {
+		case "int":
+			if n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64); err == nil {
+				out[f.name] = n
+				continue
+			}
+		case "float":
+			if n, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+				out[f.name] = n
+				continue
+			}
+		}
+		out[f.name] = v
+	}
+	return out, true
+}
+
+// String returns the expression the pattern was compiled from.
+func (p *Pattern) String() string {
+	return p.expr
+}
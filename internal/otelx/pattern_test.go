@@ -0,0 +1,78 @@
+package otelx
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCompileRegex(t *testing.T) {
+	if _, err := CompileRegex(`(\w+) (\d+)`); err == nil {
+		t.Fatalf("pattern without named groups accepted")
+	}
+	if _, err := CompileRegex(`(?P<a>[`); err == nil {
+		t.Fatalf("invalid pattern accepted")
+	}
+	p, err := CompileRegex(`^(?P<level>\w+) \[(?P<thread>[^\]]+)\] (?P<message>.*)$`)
+	if err != nil {
+		t.Fatalf("CompileRegex: %v", err)
+	}
+	m, ok := p.Match("WARN [main] disk low")
+	want := map[string]any{"level": "WARN", "thread": "main", "message": "disk low"}
+	if !ok || !reflect.DeepEqual(m, want) {
+		t.Fatalf("Match=%v %v", m, ok)
+	}
+	if _, ok := p.Match("no brackets"); ok {
+		t.Fatalf("non-matching line matched")
+	}
+}
+
+func TestCompileGrok(t *testing.T) {
+	for _, expr := range []string{"%{NOPE:x}", "%{IP} %{WORD}", "%{WORD:x"} {
+		if _, err := CompileGrok(expr); err == nil {
+			t.Fatalf("CompileGrok(%q) accepted", expr)
+		}
+	}
+
+	p, err := CompileGrok(`%{TIMESTAMP_ISO8601:timestamp} %{LOGLEVEL:level} %{IP:client} took %{NUMBER:ms:float}ms, %{INT:n:int} rows(?: (?P<extra>.+))?`)
+	if err != nil {
+		t.Fatalf("CompileGrok: %v", err)
+	}
+	m, ok := p.Match("2024-05-01T12:00:00.5Z ERROR 10.0.0.7 took 12.5ms, 3 rows")
+	want := map[string]any{
+		"timestamp": "2024-05-01T12:00:00.5Z",
+		"level":     "ERROR",
+		"client":    "10.0.0.7",
+		"ms":        12.5,
+		"n":         int64(3),
+	}
+	if !ok || !reflect.DeepEqual(m, want) {
+		t.Fatalf("Match=%#v %v", m, ok)
+	}
+	if m, _ := p.Match("2024-05-01 12:00:00 info ::1 took 1ms, 0 rows cached"); m["client"] != "::1" || m["extra"] != "cached" {
+		t.Fatalf("Match=%#v", m)
+	}
+}
+
+func TestCompileGrok_ApacheLog(t *testing.T) {
+	p, err := CompileGrok("%{COMBINEDAPACHELOG}")
+	if err != nil {
+		t.Fatalf("CompileGrok: %v", err)
+	}
+	m, ok := p.Match(`127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326 "http://example.com/start.html" "Mozilla/4.08"`)
+	if !ok {
+		t.Fatalf("no match")
+	}
+	if m["clientip"] != "127.0.0.1" || m["auth"] != "frank" || m["verb"] != "GET" || m["request"] != "/apache_pb.gif" ||
+		m["response"] != int64(200) || m["bytes"] != int64(2326) || m["timestamp"] != "10/Oct/2000:13:55:36 -0700" {
+		t.Fatalf("fields=%#v", m)
+	}
+}
+
+// Every bundled pattern must compile on its own.
+func TestGrokPatterns(t *testing.T) {
+	for name := range grokPatterns {
+		if _, err := CompileGrok("%{" + name + ":x}"); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
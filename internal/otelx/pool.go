@@ -0,0 +1,185 @@
+package otelx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	olog "go.opentelemetry.io/otel/log"
+	logsdk "go.opentelemetry.io/otel/sdk/log"
+	"go.opentelemetry.io/otel/sdk/resource"
+
+	"github.com/moritzloewenstein/otel-docker-logging-driver/internal/config"
+)
+
+const scopeName = "otel-docker-logging-driver"
+
+// Pool shares one exporter and processor between all containers whose effective
+// exporter config is the same. Entries are reference counted and shut down when the
+// last lease on them is released. Each lease has its own LoggerProvider so records
+// carry the resource of the container that produced them.
+type Pool struct {
+	mu           sync.Mutex
+	entries      map[string]*poolEntry
+	newExporter  ExporterFactory
+	newProcessor ProcessorFactory
+
+	// Records emitted through leases, and those the exporter accepted: sent to the
+	// endpoint or written to the export queue
+	emitted  atomic.Int64
+	exported atomic.Int64
+}
+
+type poolEntry struct {
+	refs int
+	proc logsdk.Processor
+	exp  Exporter
+}
+
+// Lease is a reference to a pooled exporter held by one container.
+type Lease struct {
+	pool     *Pool
+	key      string
+	entry    *poolEntry
+	provider *Provider
+	logger   olog.Logger
+	once     sync.Once
+}
+
+func NewPool(newExporter ExporterFactory, newProcessor ProcessorFactory) *Pool {
+	return &Pool{entries: map[string]*poolEntry{}, newExporter: newExporter, newProcessor: newProcessor}
+}
+
+// Acquire returns a lease on the exporter for cfg, creating it on first use. Records
+// emitted through the lease carry res, or the driver's own resource when res is nil.
+func (p *Pool) Acquire(ctx context.Context, cfg config.Config, res *resource.Resource) (*Lease, error) {
+	if res == nil {
+		res = defaultResource()
+	}
+	key := exporterKey(cfg)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	e, ok := p.entries[key]
+	if !ok {
+		exp, err := p.newExporter(ctx, cfg)
+		if err != nil {
+			return nil, err
+		}
+		counted := &countedExporter{Exporter: exp, n: &p.exported}
+		e = &poolEntry{proc: &countedProcessor{Processor: p.newProcessor(cfg, counted), n: &p.emitted}, exp: exp}
+		p.entries[key] = e
+	}
+	e.refs++
+	// The provider is never shut down itself: that would shut down the shared processor.
+	provider := logsdk.NewLoggerProvider(logsdk.WithProcessor(e.proc), logsdk.WithResource(res))
+	return &Lease{pool: p, key: key, entry: e, provider: provider, logger: provider.Logger(scopeName)}, nil
+}
+
+// Logger returns the logger records of the leasing container are emitted to.
+func (l *Lease) Logger() olog.Logger {
+	return l.logger
+}
+
+// ForceFlush exports everything the lease's processor has buffered.
+func (l *Lease) ForceFlush(ctx context.Context) error {
+	return l.provider.ForceFlush(ctx)
+}
+
+// queued reports whether the lease's exporter has batches waiting in its export queue.
+func (l *Lease) queued() bool {
+	q, ok := l.entry.exp.(*queueExporter)
+	return ok && !q.q.Empty()
+}
+
+// Release drops the reference after exporting what the processor has buffered; the
+// last release shuts down the exporter. Releasing more than once is a no-op.
+func (l *Lease) Release(ctx context.Context) error {
+	var err error
+	l.once.Do(func() {
+		p := l.pool
+		p.mu.Lock()
+		l.entry.refs--
+		last := l.entry.refs == 0
+		if last {
+			delete(p.entries, l.key)
+		}
+		p.mu.Unlock()
+		if last {
+			err = l.entry.proc.Shutdown(ctx)
+		} else {
+			err = l.entry.proc.ForceFlush(ctx)
+		}
+	})
+	return err
+}
+
+// Shutdown shuts down every pooled exporter regardless of outstanding leases.
+func (p *Pool) Shutdown(ctx context.Context) error {
+	p.mu.Lock()
+	entries := p.entries
+	p.entries = map[string]*poolEntry{}
+	p.mu.Unlock()
+	var errs []error
+	for _, e := range entries {
+		errs = append(errs, e.proc.Shutdown(ctx))
+	}
+	return errors.Join(errs...)
+}
+
+// Pending returns how many emitted records have not been exported, or written to the
+// export queue: those still buffered and those whose export failed. After Shutdown
+// these records are lost.
+func (p *Pool) Pending() int64 {
+	return p.emitted.Load() - p.exported.Load()
+}
+
+// Len returns the number of live exporters.
+func (p *Pool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.entries)
+}
+
+// exporterKey identifies the exporter a config resolves to.
+func exporterKey(cfg config.Config) string {
+	headers := make([]string, 0, len(cfg.Headers))
+	for k, v := range cfg.Headers {
+		headers = append(headers, k+"="+v)
+	}
+	sort.Strings(headers)
+	protocol := cfg.Protocol
+	if protocol == "" {
+		protocol = "grpc"
+	}
+	return fmt.Sprintf("%s|%s|%t|%s|%s|%s|%s|%s", cfg.Endpoint, protocol, cfg.Insecure, cfg.Compression, strings.Join(headers, ","),
+		cfg.Certificate, cfg.ClientCertificate, cfg.ClientKey)
+}
+
+// countedProcessor counts the records emitted to the processor.
+type countedProcessor struct {
+	logsdk.Processor
+	n *atomic.Int64
+}
+
+func (p *countedProcessor) OnEmit(ctx context.Context, r *logsdk.Record) error {
+	p.n.Add(1)
+	return p.Processor.OnEmit(ctx, r)
+}
+
+// countedExporter counts the records of export requests that succeeded.
+type countedExporter struct {
+	Exporter
+	n *atomic.Int64
+}
+
+func (e *countedExporter) Export(ctx context.Context, recs []logsdk.Record) error {
+	err := e.Exporter.Export(ctx, recs)
+	if err == nil {
+		e.n.Add(int64(len(recs)))
+	}
+	return err
+}
@@ -0,0 +1,131 @@
+package otelx
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	olog "go.opentelemetry.io/otel/log"
+	logsdk "go.opentelemetry.io/otel/sdk/log"
+
+	"github.com/moritzloewenstein/otel-docker-logging-driver/internal/config"
+)
+
+type countingExporter struct {
+	mu       sync.Mutex
+	records  int
+	shutdown bool
+}
+
+func (e *countingExporter) Export(_ context.Context, recs []logsdk.Record) error {
+	e.mu.Lock()
+	e.records += len(recs)
+	e.mu.Unlock()
+	return nil
+}
+
+func (e *countingExporter) Shutdown(context.Context) error {
+	e.mu.Lock()
+	e.shutdown = true
+	e.mu.Unlock()
+	return nil
+}
+
+func (e *countingExporter) ForceFlush(context.Context) error { return nil }
+
+func TestPool_SharesAndReleases(t *testing.T) {
+	ctx := context.Background()
+	exporters := map[string]*countingExporter{}
+	pool := NewPool(
+		func(_ context.Context, cfg config.Config) (Exporter, error) {
+			e := &countingExporter{}
+			exporters[cfg.Endpoint] = e
+			return e, nil
+		},
+		func(_ config.Config, e Exporter) logsdk.Processor { return logsdk.NewSimpleProcessor(e) },
+	)
+
+	a := config.Config{Endpoint: "a:4317", Headers: map[string]string{"k": "v"}}
+	l1, _ := pool.Acquire(ctx, a, nil)
+	// Same effective config (protocol defaults to grpc, same headers) shares the exporter.
+	l2, _ := pool.Acquire(ctx, config.Config{Endpoint: "a:4317", Protocol: "grpc", Headers: map[string]string{"k": "v"}}, nil)
+	l3, _ := pool.Acquire(ctx, config.Config{Endpoint: "b:4317"}, nil)
+	if pool.Len() != 2 || len(exporters) != 2 {
+		t.Fatalf("entries=%d exporters=%d", pool.Len(), len(exporters))
+	}
+
+	var rec olog.Record
+	l1.Logger().Emit(ctx, rec)
+	l2.Logger().Emit(ctx, rec)
+	l3.Logger().Emit(ctx, rec)
+	if exporters["a:4317"].records != 2 || exporters["b:4317"].records != 1 {
+		t.Fatalf("routing a=%d b=%d", exporters["a:4317"].records, exporters["b:4317"].records)
+	}
+
+	_ = l1.Release(ctx)
+	_ = l1.Release(ctx) // idempotent
+	if exporters["a:4317"].shutdown || pool.Len() != 2 {
+		t.Fatalf("exporter shut down while still leased")
+	}
+	_ = l2.Release(ctx)
+	if !exporters["a:4317"].shutdown || pool.Len() != 1 {
+		t.Fatalf("exporter not shut down after last release")
+	}
+
+	_ = pool.Shutdown(ctx)
+	if !exporters["b:4317"].shutdown || pool.Len() != 0 {
+		t.Fatalf("pool shutdown incomplete")
+	}
+}
+
+func TestPool_Pending(t *testing.T) {
+	ctx := context.Background()
+	pool := NewPool(
+		func(context.Context, config.Config) (Exporter, error) { return &countingExporter{}, nil },
+		func(_ config.Config, e Exporter) logsdk.Processor {
+			return logsdk.NewBatchProcessor(e, logsdk.WithExportInterval(time.Hour))
+		},
+	)
+	l, _ := pool.Acquire(ctx, config.Config{Endpoint: "a:4317"}, nil)
+	var rec olog.Record
+	for range 3 {
+		l.Logger().Emit(ctx, rec)
+	}
+	if n := pool.Pending(); n != 3 {
+		t.Fatalf("pending before flush=%d", n)
+	}
+	_ = l.ForceFlush(ctx)
+	if n := pool.Pending(); n != 0 {
+		t.Fatalf("pending after flush=%d", n)
+	}
+	_ = pool.Shutdown(ctx)
+}
+
+// failingExporter rejects every export, like an unreachable collector.
+type failingExporter struct{ countingExporter }
+
+func (e *failingExporter) Export(context.Context, []logsdk.Record) error {
+	return errors.New("connection refused")
+}
+
+func TestPool_PendingAfterFailedExport(t *testing.T) {
+	ctx := context.Background()
+	pool := NewPool(
+		func(context.Context, config.Config) (Exporter, error) { return &failingExporter{}, nil },
+		func(_ config.Config, e Exporter) logsdk.Processor {
+			return logsdk.NewBatchProcessor(e, logsdk.WithExportInterval(time.Hour))
+		},
+	)
+	l, _ := pool.Acquire(ctx, config.Config{Endpoint: "a:4317"}, nil)
+	var rec olog.Record
+	for range 3 {
+		l.Logger().Emit(ctx, rec)
+	}
+	_ = pool.Shutdown(ctx)
+	// The records were given up on, not exported.
+	if n := pool.Pending(); n != 3 {
+		t.Fatalf("pending after failed export=%d", n)
+	}
+}
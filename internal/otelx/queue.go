@@ -0,0 +1,436 @@
+package otelx
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	logsdk "go.opentelemetry.io/otel/sdk/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/moritzloewenstein/otel-docker-logging-driver/internal/config"
+	"github.com/moritzloewenstein/otel-docker-logging-driver/internal/diskqueue"
+)
+
+const (
+	replayMinBackoff  = time.Second
+	replayMaxBackoff  = 30 * time.Second
+	drainPollInterval = 50 * time.Millisecond
+
+	// How often QUEUE_DIR is checked for queues no exporter has open
+	queueReplayInterval = time.Minute
+	// How long a leftover queue's exporter gets to deliver once it is released
+	queueReleaseTimeout = 5 * time.Second
+	// How long opening a queue waits for the exporter that used it before to close it
+	queueDirWait = 10 * time.Second
+
+	// The exporter config stored in every queue directory
+	queueConfigFile = "exporter.json"
+)
+
+// queueDirs tracks the queue directories open in this process. A directory is used by
+// one queue at a time, and the queues below one QUEUE_DIR share its size budget.
+var queueDirs = struct {
+	sync.Mutex
+	// Closed when the queue in the directory is closed
+	open    map[string]chan struct{}
+	budgets map[string]*diskqueue.Budget
+}{open: map[string]chan struct{}{}, budgets: map[string]*diskqueue.Budget{}}
+
+// queueExporter persists every batch handed to it by the batch processor to an
+// on-disk queue and replays the queue, in order, to the wrapped exporter. Batches
+// are only removed from disk once the endpoint accepted them, so they survive
+// collector outages and plugin restarts. Records still waiting in the batch
+// processor's memory are not covered.
+type queueExporter struct {
+	next    Exporter
+	q       *diskqueue.Queue
+	cancel  context.CancelFunc
+	done    chan struct{}
+	backoff time.Duration
+	once    sync.Once
+	// Called with the reason and record count of batches the queue drops; may be nil
+	dropped func(reason string, n int)
+	// The queue's directory, removed by Shutdown once delivered, and the release of
+	// its lock; unset for queues not opened by newQueueExporter
+	dir    string
+	unlock func()
+}
+
+// newQueueExporter opens the queue of cfg's exporter below cfg.QueueDir and starts
+// replaying it to next. Every exporter config gets its own subdirectory, named after
+// a hash of the config so headers do not end up in path names; the config itself is
+// stored in the directory so ReplayQueues can deliver what is left in it. All queues
+// below cfg.QueueDir share cfg.QueueMaxSize.
+func newQueueExporter(next Exporter, cfg config.Config, dropped func(reason string, n int)) (*queueExporter, error) {
+	dir := queueDir(cfg)
+	unlock, err := lockQueueDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	q, err := diskqueue.Open(diskqueue.Options{
+		Dir:          dir,
+		MaxBytes:     cfg.QueueMaxSize,
+		SegmentBytes: cfg.QueueSegmentSize,
+		Fsync:        cfg.QueueFsync,
+		OnEvict:      func(batches [][]byte) { evicted(cfg.Endpoint, batches, dropped) },
+		Budget:       queueBudget(cfg.QueueDir, cfg.QueueMaxSize),
+	})
+	if err != nil {
+		unlock()
+		return nil, fmt.Errorf("open export queue: %w", err)
+	}
+	if err := writeQueueConfig(dir, cfg); err != nil {
+		_ = q.Close()
+		unlock()
+		return nil, fmt.Errorf("open export queue: %w", err)
+	}
+	e := startQueueExporter(next, q, replayMinBackoff)
+	e.dropped = dropped
+	e.dir, e.unlock = dir, unlock
+	return e, nil
+}
+
+func queueDir(cfg config.Config) string {
+	sum := sha256.Sum256([]byte(exporterKey(cfg)))
+	return filepath.Join(cfg.QueueDir, hex.EncodeToString(sum[:8]))
+}
+
+// lockQueueDir reserves a queue directory for one queue, waiting up to queueDirWait
+// for the exporter that used it before, which may still be shutting down.
+func lockQueueDir(dir string) (func(), error) {
+	timeout := time.NewTimer(queueDirWait)
+	defer timeout.Stop()
+	for {
+		queueDirs.Lock()
+		closed, busy := queueDirs.open[dir]
+		if !busy {
+			closed = make(chan struct{})
+			queueDirs.open[dir] = closed
+			queueDirs.Unlock()
+			return func() {
+				queueDirs.Lock()
+				delete(queueDirs.open, dir)
+				queueDirs.Unlock()
+				close(closed)
+			}, nil
+		}
+		queueDirs.Unlock()
+		select {
+		case <-closed:
+		case <-timeout.C:
+			return nil, fmt.Errorf("open export queue: %s is still in use", dir)
+		}
+	}
+}
+
+func queueDirOpen(dir string) bool {
+	queueDirs.Lock()
+	defer queueDirs.Unlock()
+	_, ok := queueDirs.open[dir]
+	return ok
+}
+
+// queueBudget returns the size budget shared by the queues below root.
+func queueBudget(root string, maxBytes int64) *diskqueue.Budget {
+	queueDirs.Lock()
+	defer queueDirs.Unlock()
+	b, ok := queueDirs.budgets[root]
+	if !ok {
+		b = diskqueue.NewBudget(maxBytes)
+		queueDirs.budgets[root] = b
+	}
+	return b
+}
+
+// queueConfig is the part of the config identifying an exporter, as stored in its
+// queue directory.
+type queueConfig struct {
+	Endpoint          string            `json:"endpoint"`
+	Protocol          string            `json:"protocol,omitempty"`
+	Insecure          bool              `json:"insecure,omitempty"`
+	Compression       string            `json:"compression,omitempty"`
+	Headers           map[string]string `json:"headers,omitempty"`
+	Certificate       string            `json:"certificate,omitempty"`
+	ClientCertificate string            `json:"client_certificate,omitempty"`
+	ClientKey         string            `json:"client_key,omitempty"`
+}
+
+func writeQueueConfig(dir string, cfg config.Config) error {
+	b, err := json.Marshal(queueConfig{
+		Endpoint: cfg.Endpoint, Protocol: cfg.Protocol, Insecure: cfg.Insecure, Compression: cfg.Compression,
+		Headers: cfg.Headers, Certificate: cfg.Certificate, ClientCertificate: cfg.ClientCertificate, ClientKey: cfg.ClientKey,
+	})
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, queueConfigFile)
+	if err := os.WriteFile(path+".tmp", b, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// readQueueConfig returns base with the exporter config stored in a queue directory.
+func readQueueConfig(dir string, base config.Config) (config.Config, error) {
+	b, err := os.ReadFile(filepath.Join(dir, queueConfigFile))
+	if err != nil {
+		return base, err
+	}
+	var qc queueConfig
+	if err := json.Unmarshal(b, &qc); err != nil {
+		return base, fmt.Errorf("parse %s: %w", queueConfigFile, err)
+	}
+	cfg := base
+	cfg.Endpoint, cfg.Protocol, cfg.Insecure, cfg.Compression = qc.Endpoint, qc.Protocol, qc.Insecure, qc.Compression
+	cfg.Headers, cfg.Certificate, cfg.ClientCertificate, cfg.ClientKey = qc.Headers, qc.Certificate, qc.ClientCertificate, qc.ClientKey
+	if cfg.Headers == nil {
+		cfg.Headers = map[string]string{}
+	}
+	if queueDir(cfg) != filepath.Clean(dir) {
+		return base, fmt.Errorf("%s does not match the queue directory", queueConfigFile)
+	}
+	return cfg, nil
+}
+
+// ReplayQueues delivers the batches left in the export queues below base.QueueDir
+// that no exporter has open: those of exporters no container uses any more, from
+// earlier runs, or released before their queue was delivered. It looks for them at
+// once and then every queueReplayInterval until ctx is done. Each queue is leased
+// with the exporter config stored in it, on top of base, until it is empty; its
+// exporter then removes the directory on release.
+func (p *Pool) ReplayQueues(ctx context.Context, base config.Config) {
+	if base.QueueDir == "" {
+		return
+	}
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		replaying = map[string]bool{}
+	)
+	defer wg.Wait()
+	t := time.NewTicker(queueReplayInterval)
+	defer t.Stop()
+	for {
+		entries, err := os.ReadDir(base.QueueDir)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			slog.Warn("export queue: cannot list leftover queues", "dir", base.QueueDir, "error", err)
+		}
+		for _, e := range entries {
+			dir := filepath.Join(base.QueueDir, e.Name())
+			mu.Lock()
+			skip := !e.IsDir() || replaying[dir] || queueDirOpen(dir)
+			if !skip {
+				replaying[dir] = true
+			}
+			mu.Unlock()
+			if skip {
+				continue
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				p.replayQueue(ctx, dir, base)
+				mu.Lock()
+				delete(replaying, dir)
+				mu.Unlock()
+			}()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// replayQueue leases the exporter of a leftover queue until the queue is empty or ctx
+// is done.
+func (p *Pool) replayQueue(ctx context.Context, dir string, base config.Config) {
+	cfg, err := readQueueConfig(dir, base)
+	if err != nil {
+		slog.Warn("export queue: cannot replay leftover queue", "dir", dir, "error", err)
+		return
+	}
+	lease, err := p.Acquire(ctx, cfg, nil)
+	if err != nil {
+		slog.Warn("export queue: cannot replay leftover queue", "dir", dir, "endpoint", cfg.Endpoint, "error", err)
+		return
+	}
+	slog.Info("export queue: replaying leftover queue", "dir", dir, "endpoint", cfg.Endpoint)
+	t := time.NewTicker(drainPollInterval)
+	defer t.Stop()
+	for lease.queued() && ctx.Err() == nil {
+		select {
+		case <-ctx.Done():
+		case <-t.C:
+		}
+	}
+	rctx, cancel := context.WithTimeout(context.Background(), queueReleaseTimeout)
+	defer cancel()
+	if err := lease.Release(rctx); err != nil {
+		slog.Warn("export queue: cannot release leftover queue", "dir", dir, "error", err)
+	}
+}
+
+// evicted reports batches the full queue dropped before they were delivered.
+func evicted(endpoint string, batches [][]byte, dropped func(reason string, n int)) {
+	var n int
+	for _, b := range batches {
+		if recs, err := unmarshalRecords(b); err == nil {
+			n += len(recs)
+		}
+	}
+	slog.Warn("export queue full, dropping oldest batches", "endpoint", endpoint, "batches", len(batches), "records", n)
+	if dropped != nil {
+		dropped(DropEvicted, n)
+	}
+}
+
+func startQueueExporter(next Exporter, q *diskqueue.Queue, backoff time.Duration) *queueExporter {
+	ctx, cancel := context.WithCancel(context.Background())
+	e := &queueExporter{next: next, q: q, cancel: cancel, done: make(chan struct{}), backoff: backoff}
+	go e.replay(ctx)
+	return e
+}
+
+// Export appends the batch to the queue; delivery happens asynchronously.
+func (e *queueExporter) Export(_ context.Context, recs []logsdk.Record) error {
+	if len(recs) == 0 {
+		return nil
+	}
+	b, err := marshalRecords(recs)
+	if err != nil {
+		return fmt.Errorf("encode logs for export queue: %w", err)
+	}
+	return e.q.Append(b)
+}
+
+func (e *queueExporter) replay(ctx context.Context) {
+	defer close(e.done)
+	for {
+		data, pos, err := e.q.Peek(ctx)
+		if err != nil {
+			return
+		}
+		recs, err := unmarshalRecords(data)
+		if err != nil {
+			// A batch that cannot be decoded will never be delivered; skip it.
+			slog.Error("export queue: dropping undecodable batch", "error", err)
+			_ = e.q.Ack(pos)
+			continue
+		}
+		backoff := e.backoff
+		for {
+			err := e.next.Export(ctx, recs)
+			if err == nil {
+				break
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			if permanentExportError(err) {
+				// Retrying would block every batch behind this one for good.
+				slog.Error("export queue: endpoint rejected batch, dropping it", "records", len(recs), "error", err)
+				if e.dropped != nil {
+					e.dropped(DropRejected, len(recs))
+				}
+				break
+			}
+			slog.Warn("export queue: export failed, retrying", "backoff", backoff, "error", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			backoff = min(2*backoff, replayMaxBackoff)
+		}
+		if err := e.q.Ack(pos); err != nil && !errors.Is(err, diskqueue.ErrClosed) {
+			slog.Error("export queue: cannot acknowledge batch", "error", err)
+		}
+	}
+}
+
+// httpStatusError matches the error the OTLP/HTTP exporter returns for a response
+// status it does not retry itself.
+var httpStatusError = regexp.MustCompile(`failed to send logs to \S+: (\d{3})\b`)
+
+// permanentExportError reports whether the endpoint rejected a batch in a way that
+// resending the same batch cannot fix: gRPC InvalidArgument, or an HTTP 4xx status
+// other than those caused by authentication, the endpoint's path, or throttling.
+// Everything else, including unreachable endpoints, is retried.
+func permanentExportError(err error) bool {
+	if st, ok := status.FromError(err); ok {
+		return st.Code() == codes.InvalidArgument
+	}
+	if m := httpStatusError.FindStringSubmatch(err.Error()); m != nil {
+		code, _ := strconv.Atoi(m[1])
+		switch code {
+		case http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound,
+			http.StatusProxyAuthRequired, http.StatusRequestTimeout, http.StatusTooManyRequests:
+			return false
+		}
+		return code >= 400 && code < 500
+	}
+	return false
+}
+
+// drain waits until every queued batch was delivered or ctx is done.
+func (e *queueExporter) drain(ctx context.Context) error {
+	t := time.NewTicker(drainPollInterval)
+	defer t.Stop()
+	for !e.q.Empty() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-e.done:
+			return nil
+		case <-t.C:
+		}
+	}
+	return nil
+}
+
+// ForceFlush returns once the batches exported so far are persisted; it does not wait
+// for their delivery, which may take as long as the collector is down. Only Shutdown
+// tries to deliver the queue.
+func (e *queueExporter) ForceFlush(context.Context) error {
+	if err := e.q.Sync(); err != nil && !errors.Is(err, diskqueue.ErrClosed) {
+		return err
+	}
+	return nil
+}
+
+// Shutdown gives the replay until ctx is done to deliver the queue, then stops it.
+// Undelivered batches stay on disk for the next start, or for ReplayQueues; the
+// directory of a delivered queue is removed.
+func (e *queueExporter) Shutdown(ctx context.Context) error {
+	var err error
+	e.once.Do(func() {
+		_ = e.drain(ctx)
+		e.cancel()
+		<-e.done
+		empty := e.q.Empty()
+		err = e.q.Close()
+		if e.unlock != nil {
+			if err == nil && empty {
+				err = os.RemoveAll(e.dir)
+			}
+			e.unlock()
+		}
+		err = errors.Join(err, e.next.Shutdown(ctx))
+	})
+	return err
+}
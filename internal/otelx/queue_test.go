@@ -0,0 +1,348 @@
+package otelx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	olog "go.opentelemetry.io/otel/log"
+	logsdk "go.opentelemetry.io/otel/sdk/log"
+	"go.opentelemetry.io/otel/sdk/resource"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/moritzloewenstein/otel-docker-logging-driver/internal/config"
+	"github.com/moritzloewenstein/otel-docker-logging-driver/internal/diskqueue"
+)
+
+// flakyExporter fails the first failures exports and records the rest.
+type flakyExporter struct {
+	mu       sync.Mutex
+	failures int
+	recs     []logsdk.Record
+}
+
+func (e *flakyExporter) Export(_ context.Context, recs []logsdk.Record) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.failures > 0 {
+		e.failures--
+		return errors.New("collector unavailable")
+	}
+	for _, r := range recs {
+		e.recs = append(e.recs, r.Clone())
+	}
+	return nil
+}
+
+func (e *flakyExporter) bodies() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var out []string
+	for _, r := range e.recs {
+		out = append(out, r.Body().AsString())
+	}
+	return out
+}
+
+func (e *flakyExporter) Shutdown(context.Context) error   { return nil }
+func (e *flakyExporter) ForceFlush(context.Context) error { return nil }
+
+// emitRecords builds SDK records the way the pool does, with a container resource.
+func emitRecords(t *testing.T, bodies ...string) []logsdk.Record {
+	t.Helper()
+	capture := &captureProcessor{}
+	res := resource.NewSchemaless(attribute.String("service.name", "web"))
+	provider := logsdk.NewLoggerProvider(logsdk.WithProcessor(capture), logsdk.WithResource(res))
+	logger := provider.Logger(scopeName)
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{1, 2, 3},
+		SpanID:     trace.SpanID{4, 5, 6},
+		TraceFlags: trace.FlagsSampled,
+	})
+	ctx := trace.ContextWithSpanContext(context.Background(), sc)
+	for _, b := range bodies {
+		var r olog.Record
+		r.SetTimestamp(time.Unix(100, 5))
+		r.SetBody(olog.StringValue(b))
+		r.SetSeverity(olog.SeverityWarn)
+		r.SetSeverityText("WARN")
+		r.AddAttributes(
+			olog.String("docker.container.id", "abc"),
+			olog.Map("nested", olog.Int64("n", 7), olog.Slice("s", olog.BoolValue(true))),
+		)
+		logger.Emit(ctx, r)
+	}
+	return capture.recs
+}
+
+func TestMarshalRecords_RoundTrip(t *testing.T) {
+	in := emitRecords(t, "one", "two")
+	b, err := marshalRecords(in)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	out, err := unmarshalRecords(b)
+	if err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if len(out) != 2 {
+		t.Fatalf("records=%d", len(out))
+	}
+	r := out[1]
+	if r.Body().AsString() != "two" || r.Severity() != olog.SeverityWarn || r.SeverityText() != "WARN" {
+		t.Fatalf("body=%v sev=%v text=%q", r.Body(), r.Severity(), r.SeverityText())
+	}
+	if !r.Timestamp().Equal(time.Unix(100, 5)) || !r.ObservedTimestamp().Equal(in[1].ObservedTimestamp()) {
+		t.Fatalf("ts=%v observed=%v", r.Timestamp(), r.ObservedTimestamp())
+	}
+	if r.TraceID() != in[1].TraceID() || r.SpanID() != in[1].SpanID() || r.TraceFlags() != trace.FlagsSampled {
+		t.Fatalf("trace=%v span=%v flags=%v", r.TraceID(), r.SpanID(), r.TraceFlags())
+	}
+	var nested olog.Value
+	r.WalkAttributes(func(kv olog.KeyValue) bool {
+		if kv.Key == "nested" {
+			nested = kv.Value
+		}
+		return true
+	})
+	if !nested.Equal(olog.MapValue(olog.Int64("n", 7), olog.Slice("s", olog.BoolValue(true)))) {
+		t.Fatalf("nested=%v", nested)
+	}
+	if v, _ := r.Resource().Set().Value("service.name"); v.AsString() != "web" {
+		t.Fatalf("resource=%v", r.Resource())
+	}
+	if r.InstrumentationScope().Name != scopeName {
+		t.Fatalf("scope=%v", r.InstrumentationScope())
+	}
+}
+
+func TestQueueExporter_RetriesAndReplaysAfterRestart(t *testing.T) {
+	opts := diskqueue.Options{Dir: t.TempDir(), MaxBytes: 1 << 20, SegmentBytes: 1 << 16, Fsync: diskqueue.FsyncNever}
+	ctx := context.Background()
+
+	// The collector is down: batches stay queued across a shutdown.
+	down := &flakyExporter{failures: 1 << 30}
+	q, err := diskqueue.Open(opts)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	e := startQueueExporter(down, q, time.Millisecond)
+	_ = e.Export(ctx, emitRecords(t, "a", "b"))
+	_ = e.Export(ctx, emitRecords(t, "c"))
+	sctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	_ = e.Shutdown(sctx)
+	cancel()
+	if len(down.bodies()) != 0 {
+		t.Fatalf("exported while down: %v", down.bodies())
+	}
+
+	// After a restart the queue is replayed in order once exports succeed.
+	up := &flakyExporter{failures: 2}
+	if q, err = diskqueue.Open(opts); err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	e = startQueueExporter(up, q, time.Millisecond)
+	_ = e.Export(ctx, emitRecords(t, "d"))
+	sctx, cancel = context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	if err := e.Shutdown(sctx); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	got := up.bodies()
+	if len(got) != 4 || got[0] != "a" || got[1] != "b" || got[2] != "c" || got[3] != "d" {
+		t.Fatalf("replayed=%v", got)
+	}
+}
+
+func TestQueueExporter_ForceFlushDoesNotWaitForDelivery(t *testing.T) {
+	q, err := diskqueue.Open(diskqueue.Options{Dir: t.TempDir(), MaxBytes: 1 << 20, SegmentBytes: 1 << 16, Fsync: diskqueue.FsyncAlways})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	e := startQueueExporter(&flakyExporter{failures: 1 << 30}, q, time.Millisecond)
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_ = e.Shutdown(ctx)
+	}()
+	_ = e.Export(context.Background(), emitRecords(t, "a"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	start := time.Now()
+	if err := e.ForceFlush(ctx); err != nil || time.Since(start) > time.Second {
+		t.Fatalf("ForceFlush took %v: %v", time.Since(start), err)
+	}
+	if q.Empty() {
+		t.Fatalf("batch not kept on disk")
+	}
+}
+
+func TestPermanentExportError(t *testing.T) {
+	cases := map[error]bool{
+		status.Error(codes.InvalidArgument, "bad record"):                                        true,
+		fmt.Errorf("export: %w", status.Error(codes.InvalidArgument, "bad record")):              true,
+		status.Error(codes.Unavailable, "connection refused"):                                    false,
+		status.Error(codes.Unauthenticated, "missing token"):                                     false,
+		errors.New("failed to send logs to http://c:4318/v1/logs: 400 Bad Request (body: x)"):    true,
+		errors.New("failed to send logs to http://c:4318/v1/logs: 413 Request Entity Too Large"): true,
+		errors.New("failed to send logs to http://c:4318/v1/logs: 401 Unauthorized"):             false,
+		errors.New("failed to send logs to http://c:4318/v1/logs: 500 Internal Server Error"):    false,
+		errors.New("retry-able request failure: body: overloaded"):                               false,
+		errors.New("dial tcp 10.0.0.1:4318: connect: connection refused"):                        false,
+	}
+	for err, want := range cases {
+		if got := permanentExportError(err); got != want {
+			t.Errorf("permanentExportError(%q)=%v", err, got)
+		}
+	}
+}
+
+// rejectingExporter rejects batches holding a record with body "bad" and records the rest.
+type rejectingExporter struct{ flakyExporter }
+
+func (e *rejectingExporter) Export(ctx context.Context, recs []logsdk.Record) error {
+	for _, r := range recs {
+		if r.Body().AsString() == "bad" {
+			return status.Error(codes.InvalidArgument, "invalid record")
+		}
+	}
+	return e.flakyExporter.Export(ctx, recs)
+}
+
+func TestQueueExporter_DropsRejectedBatch(t *testing.T) {
+	q, err := diskqueue.Open(diskqueue.Options{Dir: t.TempDir(), MaxBytes: 1 << 20, SegmentBytes: 1 << 16, Fsync: diskqueue.FsyncNever})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	next := &rejectingExporter{}
+	e := startQueueExporter(next, q, time.Millisecond)
+	var rejected atomic.Int64
+	e.dropped = func(reason string, n int) {
+		if reason == DropRejected {
+			rejected.Add(int64(n))
+		}
+	}
+
+	ctx := context.Background()
+	_ = e.Export(ctx, emitRecords(t, "bad", "x"))
+	_ = e.Export(ctx, emitRecords(t, "good"))
+	sctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	if err := e.Shutdown(sctx); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	if got := next.bodies(); len(got) != 1 || got[0] != "good" || rejected.Load() != 2 {
+		t.Fatalf("delivered=%v rejected=%d", got, rejected.Load())
+	}
+}
+
+func TestQueueExporter_CountsEvictedRecords(t *testing.T) {
+	cfg := config.Config{Endpoint: "c:4317", QueueDir: t.TempDir(), QueueMaxSize: 2048, QueueSegmentSize: 512, QueueFsync: "never"}
+	var evicted atomic.Int64
+	dropped := func(reason string, n int) {
+		if reason == DropEvicted {
+			evicted.Add(int64(n))
+		}
+	}
+	ctx := context.Background()
+
+	// The collector is down while far more than the queue holds is exported.
+	e, err := newQueueExporter(&flakyExporter{failures: 1 << 30}, cfg, dropped)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	const total = 100
+	for i := range total {
+		_ = e.Export(ctx, emitRecords(t, fmt.Sprintf("record %03d", i)))
+	}
+	sctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	_ = e.Shutdown(sctx)
+	cancel()
+	if evicted.Load() == 0 {
+		t.Fatalf("nothing evicted")
+	}
+
+	// Every record was either evicted and counted, or is delivered later.
+	up := &flakyExporter{}
+	if e, err = newQueueExporter(up, cfg, dropped); err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	sctx, cancel = context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	_ = e.Shutdown(sctx)
+	if n := int64(len(up.bodies())) + evicted.Load(); n != total {
+		t.Fatalf("delivered=%d evicted=%d", len(up.bodies()), evicted.Load())
+	}
+}
+
+func TestPool_ReplaysLeftoverQueues(t *testing.T) {
+	old := config.Config{Endpoint: "c:4317", Headers: map[string]string{"authorization": "old"},
+		QueueDir: t.TempDir(), QueueMaxSize: 1 << 20, QueueSegmentSize: 1 << 16, QueueFsync: "never"}
+	ctx := context.Background()
+
+	// Batches are left behind by an exporter whose header was rotated since.
+	e, err := newQueueExporter(&flakyExporter{failures: 1 << 30}, old, nil)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	_ = e.Export(ctx, emitRecords(t, "a", "b"))
+	sctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	_ = e.Shutdown(sctx)
+	cancel()
+	dir := queueDir(old)
+	if _, err := os.Stat(dir); err != nil {
+		t.Fatalf("queue not kept: %v", err)
+	}
+
+	// They are delivered with the exporter config stored in the queue, not the
+	// current one, and the emptied queue is removed.
+	base := old
+	base.Headers = map[string]string{"authorization": "new"}
+	up := &flakyExporter{}
+	var mu sync.Mutex
+	var headers []string
+	pool := NewPool(
+		func(_ context.Context, cfg config.Config) (Exporter, error) {
+			mu.Lock()
+			headers = append(headers, cfg.Headers["authorization"])
+			mu.Unlock()
+			return newQueueExporter(up, cfg, nil)
+		},
+		func(_ config.Config, e Exporter) logsdk.Processor { return logsdk.NewSimpleProcessor(e) },
+	)
+	rctx, stop := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		pool.ReplayQueues(rctx, base)
+		close(done)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(dir); errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("leftover queue not replayed, delivered=%v", up.bodies())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	stop()
+	<-done
+	mu.Lock()
+	defer mu.Unlock()
+	if got := up.bodies(); len(got) != 2 || got[0] != "a" || got[1] != "b" || len(headers) != 1 || headers[0] != "old" {
+		t.Fatalf("delivered=%v headers=%v", got, headers)
+	}
+	if pool.Len() != 0 {
+		t.Fatalf("leftover exporter still leased")
+	}
+}
@@ -0,0 +1,147 @@
+package otelx
+
+import (
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+
+	olog "go.opentelemetry.io/otel/log"
+)
+
+var levelNames = map[string]olog.Severity{
+	"trace":       olog.SeverityTrace,
+	"trc":         olog.SeverityTrace,
+	"debug":       olog.SeverityDebug,
+	"dbg":         olog.SeverityDebug,
+	"info":        olog.SeverityInfo,
+	"inf":         olog.SeverityInfo,
+	"information": olog.SeverityInfo,
+	"notice":      olog.SeverityInfo2,
+	"warn":        olog.SeverityWarn,
+	"wrn":         olog.SeverityWarn,
+	"warning":     olog.SeverityWarn,
+	"error":       olog.SeverityError,
+	"err":         olog.SeverityError,
+	"eror":        olog.SeverityError,
+	"crit":        olog.SeverityFatal,
+	"critical":    olog.SeverityFatal,
+	"fatal":       olog.SeverityFatal,
+	"ftl":         olog.SeverityFatal,
+	"panic":       olog.SeverityFatal2,
+	"alert":       olog.SeverityFatal3,
+	"emerg":       olog.SeverityFatal4,
+	"emergency":   olog.SeverityFatal4,
+}
+
+// syslogSeverities maps syslog severities (priority % 8) to their keyword and OTel severity.
+var syslogSeverities = [8]struct {
+	text string
+	sev  olog.Severity
+}{
+	{"emerg", olog.SeverityFatal4},
+	{"alert", olog.SeverityFatal3},
+	{"crit", olog.SeverityFatal},
+	{"err", olog.SeverityError},
+	{"warning", olog.SeverityWarn},
+	{"notice", olog.SeverityInfo2},
+	{"info", olog.SeverityInfo},
+	{"debug", olog.SeverityDebug},
+}
+
+// bunyanLevels are the numeric levels 10..60 used by bunyan and pino.
+var bunyanLevels = [6]olog.Severity{
+	olog.SeverityTrace, olog.SeverityDebug, olog.SeverityInfo, olog.SeverityWarn, olog.SeverityError, olog.SeverityFatal,
+}
+
+var klogLevels = map[byte]struct {
+	text string
+	sev  olog.Severity
+}{
+	'I': {"INFO", olog.SeverityInfo},
+	'W': {"WARNING", olog.SeverityWarn},
+	'E': {"ERROR", olog.SeverityError},
+	'F': {"FATAL", olog.SeverityFatal},
+}
+
+var (
+	syslogPrefix = regexp.MustCompile(`^<(\d{1,3})>`)
+	klogPrefix   = regexp.MustCompile(`^[IWEF]\d{4} `)
+	levelPrefix  = regexp.MustCompile(`^\[?([A-Za-z]+)(\]|:|\b)`)
+)
+
+// ParseSeverity maps a level name (info, WARN, err, ...) onto a severity. Numeric levels
+// are read as bunyan/pino levels (10-60) or, for 1-24, as OTel severity numbers.
+func ParseSeverity(level string) (olog.Severity, bool) {
+	level = strings.TrimSpace(level)
+	if sev, ok := levelNames[strings.ToLower(level)]; ok {
+		return sev, true
+	}
+	if n, err := strconv.Atoi(level); err == nil {
+		return numericSeverity(n)
+	}
+	return olog.SeverityUndefined, false
+}
+
+func numericSeverity(n int) (olog.Severity, bool) {
+	switch {
+	case n >= 10 && n <= 60 && n%10 == 0:
+		return bunyanLevels[n/10-1], true
+	case n >= int(olog.SeverityTrace1) && n <= int(olog.SeverityFatal4):
+		return olog.Severity(n), true
+	}
+	return olog.SeverityUndefined, false
+}
+
+// FieldSeverity reads the level from the first of keys present in parsed fields and
+// returns the severity together with the level text as found.
+func FieldSeverity(fields map[string]any, keys []string) (olog.Severity, string, bool) {
+	for _, k := range keys {
+		v, ok := fields[k]
+		if !ok {
+			continue
+		}
+		var text string
+		switch t := v.(type) {
+		case string:
+			text = t
+		case json.Number:
+			text = t.String()
+		case float64:
+			text = strconv.FormatFloat(t, 'f', -1, 64)
+		default:
+			continue
+		}
+		if sev, ok := ParseSeverity(text); ok {
+			return sev, text, true
+		}
+	}
+	return olog.SeverityUndefined, "", false
+}
+
+// DetectSeverity recognises a level at the start of a plain text line: a syslog <N>
+// priority, a klog-style single letter header (I0102 15:04:05...), or a level token
+// such as "WARN ...", "[info] ..." or "error: ...". Lower-case tokens are only accepted
+// when bracketed or followed by a colon to avoid matching ordinary words.
+func DetectSeverity(line string) (olog.Severity, string, bool) {
+	if m := syslogPrefix.FindStringSubmatch(line); m != nil {
+		if pri, err := strconv.Atoi(m[1]); err == nil && pri <= 191 {
+			s := syslogSeverities[pri%8]
+			return s.sev, s.text, true
+		}
+	}
+	if klogPrefix.MatchString(line) {
+		l := klogLevels[line[0]]
+		return l.sev, l.text, true
+	}
+	if m := levelPrefix.FindStringSubmatch(line); m != nil {
+		token := m[1]
+		if m[2] == "" && token != strings.ToUpper(token) {
+			return olog.SeverityUndefined, "", false
+		}
+		if sev, ok := levelNames[strings.ToLower(token)]; ok {
+			return sev, token, true
+		}
+	}
+	return olog.SeverityUndefined, "", false
+}
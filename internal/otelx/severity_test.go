@@ -0,0 +1,64 @@
+package otelx
+
+import (
+	"encoding/json"
+	"testing"
+
+	olog "go.opentelemetry.io/otel/log"
+)
+
+func TestDetectSeverity(t *testing.T) {
+	cases := []struct {
+		line string
+		sev  olog.Severity
+		text string
+	}{
+		{"<11>Jan 1 host app: boom", olog.SeverityError, "err"},
+		{"<14>hello", olog.SeverityInfo, "info"},
+		{"<8>x", olog.SeverityFatal4, "emerg"},
+		{"I0102 15:04:05.000000 1 main.go:1] started", olog.SeverityInfo, "INFO"},
+		{"W0102 15:04:05.000000 1 main.go:1] careful", olog.SeverityWarn, "WARNING"},
+		{"DEBUG starting", olog.SeverityDebug, "DEBUG"},
+		{"WARN[0000] disk low", olog.SeverityWarn, "WARN"},
+		{"[error] failed", olog.SeverityError, "error"},
+		{"info: ready", olog.SeverityInfo, "info"},
+		{"FATAL oom", olog.SeverityFatal, "FATAL"},
+		{"Notice: maintenance", olog.SeverityInfo2, "Notice"},
+	}
+	for _, c := range cases {
+		sev, text, ok := DetectSeverity(c.line)
+		if !ok || sev != c.sev || text != c.text {
+			t.Fatalf("DetectSeverity(%q)=%v,%q,%v want %v,%q", c.line, sev, text, ok, c.sev, c.text)
+		}
+	}
+
+	for _, line := range []string{"", "hello world", "Information about the service", "errors were fixed", "I0x", "<999>x"} {
+		if sev, _, ok := DetectSeverity(line); ok {
+			t.Fatalf("DetectSeverity(%q)=%v, want no match", line, sev)
+		}
+	}
+}
+
+func TestFieldSeverity(t *testing.T) {
+	keys := []string{"level", "severity"}
+	cases := []struct {
+		fields map[string]any
+		sev    olog.Severity
+		text   string
+	}{
+		{map[string]any{"level": "warning"}, olog.SeverityWarn, "warning"},
+		{map[string]any{"severity": "CRITICAL"}, olog.SeverityFatal, "CRITICAL"},
+		{map[string]any{"level": json.Number("30")}, olog.SeverityInfo, "30"},
+		{map[string]any{"level": json.Number("17")}, olog.SeverityError, "17"},
+		{map[string]any{"level": "bogus", "severity": "debug"}, olog.SeverityDebug, "debug"},
+	}
+	for _, c := range cases {
+		sev, text, ok := FieldSeverity(c.fields, keys)
+		if !ok || sev != c.sev || text != c.text {
+			t.Fatalf("FieldSeverity(%v)=%v,%q,%v want %v,%q", c.fields, sev, text, ok, c.sev, c.text)
+		}
+	}
+	if _, _, ok := FieldSeverity(map[string]any{"msg": "x"}, keys); ok {
+		t.Fatalf("matched without level field")
+	}
+}
@@ -0,0 +1,220 @@
+package otelx
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// timestampLayouts are the formats ParseTimestamp accepts, tried in order.
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999Z0700",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999Z0700",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05,999999999",
+	"02/Jan/2006:15:04:05 -0700",
+	time.RFC1123Z,
+	time.RFC1123,
+}
+
+// ParseTimestamp reads a timestamp as logged by common applications: RFC 3339 and
+// other ISO 8601 forms, Apache/nginx access log dates, RFC 1123 and syslog's
+// "Jan _2 15:04:05", which is taken to be in the current year. Times without a zone
+// are UTC.
+func ParseTimestamp(s string) (time.Time, bool) {
+	s = strings.TrimSpace(s)
+	for _, layout := range timestampLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	if t, err := time.Parse(time.Stamp, s); err == nil {
+		return t.AddDate(time.Now().Year(), 0, 0), true
+	}
+	return time.Time{}, false
+}
+
+// Epoch units a TimestampParser can be configured with, as nanoseconds per unit.
+var epochUnits = map[string]float64{
+	"unix":    1e9,
+	"unix_ms": 1e6,
+	"unix_us": 1e3,
+	"unix_ns": 1,
+}
+
+// Named layouts a TimestampParser can be configured with besides Go layout strings.
+var namedLayouts = map[string]string{
+	"rfc3339":     time.RFC3339,
+	"rfc3339nano": time.RFC3339Nano,
+}
+
+// TimestampParser reads application timestamps from parsed fields or the start of a
+// line, in one configured format or, with "auto", any format ParseTimestamp knows
+// and epoch numbers.
+type TimestampParser struct {
+	format string
+	// Nanoseconds per unit for epoch formats, else 0
+	epoch float64
+	// Go layout for layout formats, else ""
+	layout string
+}
+
+// NewTimestampParser returns a parser for format: "auto", "rfc3339", "rfc3339nano",
+// "unix", "unix_ms", "unix_us", "unix_ns", or a Go layout string such as
+// "2006-01-02 15:04:05.000".
+func NewTimestampParser(format string) (*TimestampParser, error) {
+	p := &TimestampParser{format: format}
+	if format == "auto" {
+		return p, nil
+	}
+	if unit, ok := epochUnits[strings.ToLower(format)]; ok {
+		p.epoch = unit
+		return p, nil
+	}
+	if layout, ok := namedLayouts[strings.ToLower(format)]; ok {
+		p.layout = layout
+		return p, nil
+	}
+	// A layout has to mention at least the reference year, day or time of day.
+	if !strings.Contains(format, "2006") && !strings.Contains(format, "15") && !strings.Contains(format, "02") {
+		return nil, fmt.Errorf("timestamp format %q is neither a known format nor a Go layout", format)
+	}
+	p.layout = format
+	return p, nil
+}
+
+// Parse reads a field value: a string, or a number for epoch formats.
+func (p *TimestampParser) Parse(v any) (time.Time, bool) {
+	switch t := v.(type) {
+	case string:
+		return p.parseString(t)
+	case json.Number:
+		return p.parseString(t.String())
+	case float64:
+		return p.parseString(strconv.FormatFloat(t, 'f', -1, 64))
+	case int64:
+		return p.parseString(strconv.FormatInt(t, 10))
+	}
+	return time.Time{}, false
+}
+
+func (p *TimestampParser) parseString(s string) (time.Time, bool) {
+	s = strings.TrimSpace(s)
+	switch {
+	case p.epoch > 0:
+		return parseEpoch(s, p.epoch)
+	case p.layout != "":
+		t, err := time.Parse(p.layout, s)
+		return t, err == nil
+	}
+	if t, ok := ParseTimestamp(s); ok {
+		return t, true
+	}
+	if !epochPrefix.MatchString(s) {
+		return time.Time{}, false
+	}
+	return parseEpoch(s, autoEpochUnit(s))
+}
+
+// isoPrefix matches an ISO 8601 date and time at the start of a line, optionally
+// bracketed.
+var isoPrefix = regexp.MustCompile(`^\[?(\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}:\d{2}(?:[.,]\d+)?(?:Z|[+-]\d{2}:?\d{2})?)\]?(?:\s+|$)`)
+
+// epochPrefix matches epoch seconds, milliseconds, microseconds or nanoseconds since
+// 2001 at the start of a line; shorter numbers are more likely to be part of the
+// message.
+var epochPrefix = regexp.MustCompile(`^\[?(\d{10}(?:\d{3}){0,3}(?:\.\d+)?)\]?(?:\s+|$)`)
+
+// Prefix reads a timestamp at the start of line and returns it together with the rest
+// of the line, leading whitespace removed. It reports false if the line does not
+// start with a timestamp in the parser's format.
+func (p *TimestampParser) Prefix(line string) (time.Time, string, bool) {
+	if p.epoch > 0 || p.layout != "" {
+		// The layout spans as many whitespace-separated words as it contains.
+		n := 1
+		if p.layout != "" {
+			n = len(strings.Fields(p.layout))
+		}
+		head, rest, ok := leadingWords(line, n)
+		if !ok {
+			return time.Time{}, "", false
+		}
+		t, ok := p.parseString(strings.TrimSuffix(strings.TrimPrefix(head, "["), "]"))
+		if !ok {
+			return time.Time{}, "", false
+		}
+		return t, rest, true
+	}
+	for _, re := range []*regexp.Regexp{isoPrefix, epochPrefix} {
+		m := re.FindStringSubmatchIndex(line)
+		if m == nil {
+			continue
+		}
+		if t, ok := p.parseString(line[m[2]:m[3]]); ok {
+			return t, line[m[1]:], true
+		}
+	}
+	return time.Time{}, "", false
+}
+
+// leadingWords splits s after its first n words, dropping the whitespace around them.
+func leadingWords(s string, n int) (head, rest string, ok bool) {
+	s = strings.TrimLeft(s, " \t")
+	i := 0
+	for w := 0; w < n; w++ {
+		for i < len(s) && (s[i] == ' ' || s[i] == '\t') {
+			i++
+		}
+		if i == len(s) {
+			return "", "", false
+		}
+		for i < len(s) && s[i] != ' ' && s[i] != '\t' {
+			i++
+		}
+	}
+	return s[:i], strings.TrimLeft(s[i:], " \t"), true
+}
+
+// autoEpochUnit guesses the unit of an epoch number from its integer digits.
+func autoEpochUnit(s string) float64 {
+	digits := len(s)
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		digits = i
+	}
+	switch {
+	case digits <= 10:
+		return epochUnits["unix"]
+	case digits <= 13:
+		return epochUnits["unix_ms"]
+	case digits <= 16:
+		return epochUnits["unix_us"]
+	}
+	return epochUnits["unix_ns"]
+}
+
+func parseEpoch(s string, unit float64) (time.Time, bool) {
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		if i < 0 || i > math.MaxInt64/int64(unit) {
+			return time.Time{}, false
+		}
+		return time.Unix(0, i*int64(unit)).UTC(), true
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f < 0 || math.IsInf(f, 0) {
+		return time.Time{}, false
+	}
+	sec, frac := math.Modf(f * unit / 1e9)
+	return time.Unix(int64(sec), int64(math.Round(frac*1e6))*1e3).UTC(), true
+}
+
+// String returns the format the parser was created with.
+func (p *TimestampParser) String() string {
+	return p.format
+}
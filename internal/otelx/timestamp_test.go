@@ -0,0 +1,107 @@
+package otelx
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestParseTimestamp(t *testing.T) {
+	want := time.Date(2024, 5, 1, 12, 0, 0, 5e8, time.UTC)
+	for _, s := range []string{
+		"2024-05-01T12:00:00.5Z",
+		"2024-05-01T14:00:00.5+02:00",
+		"2024-05-01T14:00:00.5+0200",
+		"2024-05-01 12:00:00.5",
+		"2024-05-01 12:00:00,5",
+		" 2024-05-01T12:00:00.500Z ",
+	} {
+		if got, ok := ParseTimestamp(s); !ok || !got.Equal(want) {
+			t.Fatalf("ParseTimestamp(%q)=%v %v", s, got, ok)
+		}
+	}
+	if got, ok := ParseTimestamp("01/May/2024:14:00:00 +0200"); !ok || !got.Equal(want.Truncate(time.Second)) {
+		t.Fatalf("HTTP date=%v %v", got, ok)
+	}
+	if got, ok := ParseTimestamp("May  1 12:00:00"); !ok || got.Year() != time.Now().Year() || got.Month() != time.May {
+		t.Fatalf("syslog date=%v %v", got, ok)
+	}
+	for _, s := range []string{"", "yesterday", "2024-13-01T00:00:00Z"} {
+		if _, ok := ParseTimestamp(s); ok {
+			t.Fatalf("ParseTimestamp(%q) accepted", s)
+		}
+	}
+}
+
+func TestTimestampParser(t *testing.T) {
+	if _, err := NewTimestampParser("yesterday"); err == nil {
+		t.Fatalf("unknown format accepted")
+	}
+	want := time.Date(2024, 5, 1, 12, 0, 0, 123e6, time.UTC)
+	cases := []struct {
+		format string
+		value  any
+	}{
+		{"auto", "2024-05-01T12:00:00.123Z"},
+		{"auto", json.Number("1714564800.123")},
+		{"auto", json.Number("1714564800123")},
+		{"auto", "1714564800123000"},
+		{"auto", 1714564800.123},
+		{"rfc3339nano", "2024-05-01T14:00:00.123+02:00"},
+		{"unix", json.Number("1714564800.123")},
+		{"unix_ms", int64(1714564800123)},
+		{"UNIX_NS", "1714564800123000000"},
+		{"2006-01-02 15:04:05.000", "2024-05-01 12:00:00.123"},
+	}
+	for _, c := range cases {
+		p, err := NewTimestampParser(c.format)
+		if err != nil {
+			t.Fatalf("NewTimestampParser(%q): %v", c.format, err)
+		}
+		if got, ok := p.Parse(c.value); !ok || !got.Equal(want) {
+			t.Fatalf("%s: Parse(%v)=%v %v", c.format, c.value, got, ok)
+		}
+	}
+	p, _ := NewTimestampParser("unix")
+	for _, v := range []any{"soon", json.Number("-1"), true} {
+		if _, ok := p.Parse(v); ok {
+			t.Fatalf("Parse(%v) accepted", v)
+		}
+	}
+}
+
+func TestTimestampParser_Prefix(t *testing.T) {
+	want := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		format string
+		line   string
+		rest   string
+	}{
+		{"auto", "2024-05-01T12:00:00Z INFO started", "INFO started"},
+		{"auto", "[2024-05-01 12:00:00] started", "started"},
+		{"auto", "2024-05-01T12:00:00Z", ""},
+		{"auto", "1714564800 started", "started"},
+		{"auto", "1714564800000\tstarted", "started"},
+		{"rfc3339", "2024-05-01T14:00:00+02:00 started", "started"},
+		{"unix", "1714564800 started", "started"},
+		{"2006/01/02 15:04:05", "2024/05/01 12:00:00 started at 12:00", "started at 12:00"},
+		{"Jan _2 2006 15:04:05", "May  1 2024 12:00:00 started", "started"},
+	}
+	for _, c := range cases {
+		p, err := NewTimestampParser(c.format)
+		if err != nil {
+			t.Fatalf("NewTimestampParser(%q): %v", c.format, err)
+		}
+		got, rest, ok := p.Prefix(c.line)
+		if !ok || !got.Equal(want) || rest != c.rest {
+			t.Fatalf("%s: Prefix(%q)=%v %q %v", c.format, c.line, got, rest, ok)
+		}
+	}
+
+	p, _ := NewTimestampParser("auto")
+	for _, line := range []string{"200 OK", "started at 2024-05-01T12:00:00Z", "2024-05-01T12:00:00Zstarted", "12345678901234567890 big"} {
+		if _, _, ok := p.Prefix(line); ok {
+			t.Fatalf("Prefix(%q) accepted", line)
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package otelx
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	certPollInterval = 10 * time.Second
+	// Warn when less than this, or a quarter of the certificate's lifetime for
+	// short-lived certificates, is left before it expires.
+	certExpiryWarning = 72 * time.Hour
+)
+
+// certReloader holds the TLS material read from the configured PEM files and
+// reloads it when the files change, so rotated certificates are used by new
+// connections without recreating the exporter. The TLS config it hands out reads
+// the current material on every handshake.
+type certReloader struct {
+	caFile, certFile, keyFile string
+	interval                  time.Duration
+
+	mu    sync.RWMutex
+	roots *x509.CertPool
+	cert  *tls.Certificate
+	leaf  *x509.Certificate
+	stamp string
+	// Change that could not be loaded yet and how often it was tried
+	pending      string
+	pendingTries int
+	warned       *x509.Certificate
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+// newCertReloader loads the files and starts watching them. caFile replaces the
+// system roots, certFile and keyFile enable mTLS. It returns nil when no file is
+// configured.
+func newCertReloader(caFile, certFile, keyFile string, interval time.Duration) (*certReloader, error) {
+	if caFile == "" && certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+	r := &certReloader{
+		caFile: caFile, certFile: certFile, keyFile: keyFile, interval: interval,
+		stop: make(chan struct{}), done: make(chan struct{}),
+	}
+	if err := r.load(r.fileStamp()); err != nil {
+		return nil, err
+	}
+	go r.watch()
+	return r, nil
+}
+
+// fileStamp summarises modification time and size of the watched files.
+func (r *certReloader) fileStamp() string {
+	var s string
+	for _, f := range []string{r.caFile, r.certFile, r.keyFile} {
+		if f == "" {
+			continue
+		}
+		if st, err := os.Stat(f); err == nil {
+			s += fmt.Sprintf("%d/%d;", st.ModTime().UnixNano(), st.Size())
+		} else {
+			s += "missing;"
+		}
+	}
+	return s
+}
+
+// load reads all files and swaps them in at once; on error the current material is kept.
+func (r *certReloader) load(stamp string) error {
+	var roots *x509.CertPool
+	var caExpiry time.Time
+	if r.caFile != "" {
+		pemCA, err := os.ReadFile(r.caFile)
+		if err != nil {
+			return err
+		}
+		roots = x509.NewCertPool()
+		if ok := roots.AppendCertsFromPEM(pemCA); !ok {
+			return fmt.Errorf("failed to add server CA")
+		}
+		caExpiry = earliestExpiry(pemCA)
+	}
+	var cert *tls.Certificate
+	var leaf *x509.Certificate
+	if r.certFile != "" && r.keyFile != "" {
+		c, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+		if err != nil {
+			return err
+		}
+		if leaf, err = x509.ParseCertificate(c.Certificate[0]); err != nil {
+			return err
+		}
+		cert = &c
+	}
+
+	r.mu.Lock()
+	r.roots, r.cert, r.leaf, r.stamp = roots, cert, leaf, stamp
+	r.mu.Unlock()
+
+	if r.caFile != "" {
+		slog.Info("loaded TLS CA", "file", r.caFile, "expires", caExpiry)
+	}
+	if leaf != nil {
+		slog.Info("loaded TLS client certificate", "file", r.certFile, "subject", leaf.Subject.CommonName, "expires", leaf.NotAfter)
+	}
+	return nil
+}
+
+func earliestExpiry(pemData []byte) time.Time {
+	var earliest time.Time
+	for {
+		var block *pem.Block
+		if block, pemData = pem.Decode(pemData); block == nil {
+			return earliest
+		}
+		if c, err := x509.ParseCertificate(block.Bytes); err == nil && (earliest.IsZero() || c.NotAfter.Before(earliest)) {
+			earliest = c.NotAfter
+		}
+	}
+}
+
+func (r *certReloader) watch() {
+	defer close(r.done)
+	t := time.NewTicker(r.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-t.C:
+		}
+		r.check(time.Now())
+	}
+}
+
+// check reloads changed files and warns once per certificate close to its expiry.
+func (r *certReloader) check(now time.Time) {
+	r.mu.RLock()
+	current := r.stamp
+	r.mu.RUnlock()
+	if stamp := r.fileStamp(); stamp != current {
+		if err := r.load(stamp); err != nil {
+			if stamp != r.pending {
+				r.pending, r.pendingTries = stamp, 0
+			}
+			// A rotation may replace certificate and key one after the other, so only
+			// report a change that is still unloadable on the next tick.
+			if r.pendingTries++; r.pendingTries == 2 {
+				slog.Error("cannot reload TLS files, keeping current credentials", "error", err)
+			}
+		}
+	}
+
+	r.mu.Lock()
+	leaf := r.leaf
+	warn := leaf != nil && r.warned != leaf && expiresSoon(leaf, now)
+	if warn {
+		r.warned = leaf
+	}
+	r.mu.Unlock()
+	if warn {
+		slog.Warn("TLS client certificate expires soon and has not been renewed",
+			"file", r.certFile, "expires", leaf.NotAfter, "remaining", leaf.NotAfter.Sub(now).Round(time.Second))
+	}
+}
+
+func expiresSoon(c *x509.Certificate, now time.Time) bool {
+	threshold := min(certExpiryWarning, c.NotAfter.Sub(c.NotBefore)/4)
+	return c.NotAfter.Sub(now) < threshold
+}
+
+// tlsConfig returns a client TLS config that uses the material current at handshake
+// time. endpoint is the configured OTLP endpoint, whose host the server certificate
+// must be valid for.
+func (r *certReloader) tlsConfig(endpoint string) *tls.Config {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if r.caFile != "" {
+		// Standard verification is replaced by verifyServer, which uses the current roots.
+		cfg.InsecureSkipVerify = true
+		host := endpointHost(endpoint)
+		cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			return r.verifyServer(cs, host)
+		}
+	}
+	if r.certFile != "" && r.keyFile != "" {
+		cfg.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+			return r.cert, nil
+		}
+	}
+	return cfg
+}
+
+// verifyServer verifies the server certificate against the current roots and host.
+// The host comes from the configuration rather than the connection state, whose
+// ServerName is empty for IP addresses.
+func (r *certReloader) verifyServer(cs tls.ConnectionState, host string) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("tls: server presented no certificate")
+	}
+	if host == "" {
+		host = cs.ServerName
+	}
+	if host == "" {
+		return errors.New("tls: no server name to verify the certificate against")
+	}
+	r.mu.RLock()
+	roots := r.roots
+	r.mu.RUnlock()
+	opts := x509.VerifyOptions{DNSName: host, Roots: roots, Intermediates: x509.NewCertPool()}
+	for _, c := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(c)
+	}
+	_, err := cs.PeerCertificates[0].Verify(opts)
+	return err
+}
+
+// endpointHost returns the host of an http(s) URL or host:port endpoint, without
+// brackets for IPv6 addresses.
+func endpointHost(endpoint string) string {
+	if strings.Contains(endpoint, "://") {
+		if u, err := url.Parse(endpoint); err == nil {
+			return u.Hostname()
+		}
+		return ""
+	}
+	if host, _, err := net.SplitHostPort(endpoint); err == nil {
+		return host
+	}
+	return strings.Trim(endpoint, "[]")
+}
+
+// Close stops watching the files.
+func (r *certReloader) Close() {
+	if r == nil {
+		return
+	}
+	r.once.Do(func() {
+		close(r.stop)
+		<-r.done
+	})
+}
@@ -0,0 +1,162 @@
+package otelx
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func clientCN(t *testing.T, r *certReloader) string {
+	t.Helper()
+	c, err := r.tlsConfig("collector:4317").GetClientCertificate(nil)
+	if err != nil || c == nil {
+		t.Fatalf("client certificate: %v", err)
+	}
+	return c.Leaf.Subject.CommonName
+}
+
+func TestCertReloader(t *testing.T) {
+	if r, err := newCertReloader("", "", "", time.Hour); r != nil || err != nil {
+		t.Fatalf("no files: r=%v err=%v", r, err)
+	}
+	dir := t.TempDir()
+	certFile, keyFile, _ := writeClientCert(t, dir, "first", time.Now().Add(time.Hour))
+	if _, err := newCertReloader(filepath.Join(dir, "missing.pem"), "", "", time.Hour); err == nil {
+		t.Fatalf("expected error for missing CA")
+	}
+	if _, err := newCertReloader(keyFile, "", "", time.Hour); err == nil {
+		t.Fatalf("expected error for CA without certificates")
+	}
+
+	// A client certificate alone keeps the system roots.
+	r, err := newCertReloader("", certFile, keyFile, time.Hour)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	defer r.Close()
+	if cfg := r.tlsConfig("collector:4317"); cfg.InsecureSkipVerify || cfg.RootCAs != nil {
+		t.Fatalf("tls config=%+v", cfg)
+	}
+	if cn := clientCN(t, r); cn != "first" {
+		t.Fatalf("cn=%q", cn)
+	}
+
+	// A broken rotation keeps the current certificate.
+	_ = os.WriteFile(certFile, []byte("garbage"), 0o600)
+	r.check(time.Now())
+	if cn := clientCN(t, r); cn != "first" {
+		t.Fatalf("cn after broken rotation=%q", cn)
+	}
+
+	// A complete rotation is picked up by the TLS config already handed out.
+	_, _, _ = writeClientCert(t, dir, "second", time.Now().Add(time.Hour))
+	r.check(time.Now())
+	if cn := clientCN(t, r); cn != "second" {
+		t.Fatalf("cn after rotation=%q", cn)
+	}
+}
+
+func TestExpiresSoon(t *testing.T) {
+	now := time.Now()
+	_, _, day := writeClientCert(t, t.TempDir(), "day", now.Add(23*time.Hour))
+	// A 24h certificate is reported in its last 6 hours, a long-lived one 72h ahead.
+	if expiresSoon(day, now) || !expiresSoon(day, now.Add(18*time.Hour)) {
+		t.Fatalf("24h certificate")
+	}
+	_, _, year := writeClientCert(t, t.TempDir(), "year", now.Add(365*24*time.Hour))
+	if expiresSoon(year, now.Add(360*24*time.Hour)) || !expiresSoon(year, now.Add(363*24*time.Hour)) {
+		t.Fatalf("one year certificate")
+	}
+}
+
+// serverCert returns a self-signed server certificate valid for dnsNames only.
+func serverCert(t *testing.T, dnsNames ...string) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(2),
+		Subject:               pkix.Name{CommonName: "collector"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		DNSNames:              dnsNames,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestCertReloader_VerifiesEndpointHost(t *testing.T) {
+	dir := t.TempDir()
+	for _, c := range []struct {
+		name string
+		// nil uses httptest's certificate, which is valid for 127.0.0.1
+		cert    *tls.Certificate
+		wantErr string
+	}{
+		{"ip SAN", nil, ""},
+		{"dns name only", &[]tls.Certificate{serverCert(t, "collector.example")}[0], "doesn't contain any IP SANs"},
+	} {
+		srv := httptest.NewUnstartedServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+		if c.cert != nil {
+			srv.TLS = &tls.Config{Certificates: []tls.Certificate{*c.cert}}
+		}
+		srv.StartTLS()
+
+		caFile := filepath.Join(dir, "ca.pem")
+		leaf := srv.Certificate()
+		if c.cert != nil {
+			leaf, _ = x509.ParseCertificate(c.cert.Certificate[0])
+		}
+		_ = os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leaf.Raw}), 0o600)
+		r, err := newCertReloader(caFile, "", "", time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The endpoint is an IP address, for which the connection has no ServerName.
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: r.tlsConfig(srv.URL)}}
+		resp, err := client.Get(srv.URL)
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+		if (err == nil) != (c.wantErr == "") || (err != nil && !strings.Contains(err.Error(), c.wantErr)) {
+			t.Errorf("%s: err=%v", c.name, err)
+		}
+		r.Close()
+		srv.Close()
+	}
+}
+
+func TestEndpointHost(t *testing.T) {
+	for in, want := range map[string]string{
+		"https://10.0.0.5:4317":    "10.0.0.5",
+		"http://collector/v1/logs": "collector",
+		"collector:4317":           "collector",
+		"[::1]:4317":               "::1",
+		"https://[::1]:4318":       "::1",
+	} {
+		if got := endpointHost(in); got != want {
+			t.Errorf("endpointHost(%q)=%q want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package otelx
+
+import (
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ParseTraceID reads a 32 digit hex trace ID in either case. The all-zero ID is
+// invalid.
+func ParseTraceID(v any) (trace.TraceID, bool) {
+	s, ok := v.(string)
+	if !ok {
+		return trace.TraceID{}, false
+	}
+	id, err := trace.TraceIDFromHex(strings.ToLower(strings.TrimSpace(s)))
+	return id, err == nil
+}
+
+// ParseSpanID reads a 16 digit hex span ID in either case. The all-zero ID is invalid.
+func ParseSpanID(v any) (trace.SpanID, bool) {
+	s, ok := v.(string)
+	if !ok {
+		return trace.SpanID{}, false
+	}
+	id, err := trace.SpanIDFromHex(strings.ToLower(strings.TrimSpace(s)))
+	return id, err == nil
+}
+
+// ParseTraceFlags reads trace flags written as two hex digits ("01") or as a number.
+func ParseTraceFlags(v any) (trace.TraceFlags, bool) {
+	var n uint64
+	var err error
+	switch t := v.(type) {
+	case string:
+		t = strings.TrimSpace(t)
+		if len(t) != 2 {
+			return 0, false
+		}
+		n, err = strconv.ParseUint(t, 16, 8)
+	case json.Number:
+		n, err = strconv.ParseUint(t.String(), 10, 8)
+	case float64:
+		if t < 0 || t > 255 || t != float64(uint8(t)) {
+			return 0, false
+		}
+		n = uint64(t)
+	case int64:
+		if t < 0 || t > 255 {
+			return 0, false
+		}
+		n = uint64(t)
+	default:
+		return 0, false
+	}
+	if err != nil {
+		return 0, false
+	}
+	return trace.TraceFlags(n), true
+}
+
+// traceparentRE matches a W3C traceparent: version, trace ID, parent span ID and
+// flags. Versions after 00 may append further fields.
+var traceparentRE = regexp.MustCompile(`(?i)^([0-9a-f]{2})-([0-9a-f]{32})-([0-9a-f]{16})-([0-9a-f]{2})(-.*)?$`)
+
+// ParseTraceparent reads a W3C Trace Context traceparent value such as
+// 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01.
+func ParseTraceparent(s string) (trace.SpanContext, bool) {
+	m := traceparentRE.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil || strings.EqualFold(m[1], "ff") || (m[1] == "00" && m[5] != "") {
+		return trace.SpanContext{}, false
+	}
+	traceID, ok := ParseTraceID(m[2])
+	if !ok {
+		return trace.SpanContext{}, false
+	}
+	spanID, ok := ParseSpanID(m[3])
+	if !ok {
+		return trace.SpanContext{}, false
+	}
+	flags, _ := ParseTraceFlags(m[4])
+	return trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceID, SpanID: spanID, TraceFlags: flags}), true
+}
+
+// traceparentInText matches a version 00 traceparent anywhere in a line, standing on
+// its own rather than inside a longer run of hex digits.
+var traceparentInText = regexp.MustCompile(`(?i)(?:^|[^0-9a-z-])(00-[0-9a-f]{32}-[0-9a-f]{16}-[0-9a-f]{2})(?:$|[^0-9a-z-])`)
+
+// FindTraceparent finds a traceparent in a plain text line, for example in
+// `traceparent=00-4bf9...-01 GET /` or `[00-4bf9...-01] GET /`.
+func FindTraceparent(line string) (trace.SpanContext, bool) {
+	m := traceparentInText.FindStringSubmatch(line)
+	if m == nil {
+		return trace.SpanContext{}, false
+	}
+	return ParseTraceparent(m[1])
+}
@@ -0,0 +1,80 @@
+package otelx
+
+import (
+	"encoding/json"
+	"testing"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	testTraceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	testSpanID  = "00f067aa0ba902b7"
+)
+
+func TestParseIDs(t *testing.T) {
+	if id, ok := ParseTraceID("4BF92F3577B34DA6A3CE929D0E0E4736"); !ok || id.String() != testTraceID {
+		t.Fatalf("ParseTraceID=%v %v", id, ok)
+	}
+	for _, v := range []any{"", "4bf92f35", testTraceID + "00", "00000000000000000000000000000000", "zbf92f3577b34da6a3ce929d0e0e4736", 42} {
+		if _, ok := ParseTraceID(v); ok {
+			t.Fatalf("ParseTraceID(%v) accepted", v)
+		}
+	}
+	if id, ok := ParseSpanID(testSpanID); !ok || id.String() != testSpanID {
+		t.Fatalf("ParseSpanID=%v %v", id, ok)
+	}
+	for _, v := range []any{"0000000000000000", testTraceID, nil} {
+		if _, ok := ParseSpanID(v); ok {
+			t.Fatalf("ParseSpanID(%v) accepted", v)
+		}
+	}
+	for v, want := range map[any]trace.TraceFlags{"01": 1, "ff": 255, json.Number("1"): 1, float64(0): 0, int64(3): 3} {
+		if got, ok := ParseTraceFlags(v); !ok || got != want {
+			t.Fatalf("ParseTraceFlags(%v)=%v %v", v, got, ok)
+		}
+	}
+	for _, v := range []any{"1", "0x01", "zz", json.Number("256"), float64(1.5), int64(-1), true} {
+		if _, ok := ParseTraceFlags(v); ok {
+			t.Fatalf("ParseTraceFlags(%v) accepted", v)
+		}
+	}
+}
+
+func TestParseTraceparent(t *testing.T) {
+	sc, ok := ParseTraceparent("00-" + testTraceID + "-" + testSpanID + "-01")
+	if !ok || sc.TraceID().String() != testTraceID || sc.SpanID().String() != testSpanID || !sc.IsSampled() {
+		t.Fatalf("ParseTraceparent=%v %v", sc, ok)
+	}
+	// Later versions may carry more fields.
+	if _, ok := ParseTraceparent("01-" + testTraceID + "-" + testSpanID + "-00-extra"); !ok {
+		t.Fatalf("future version rejected")
+	}
+	for _, s := range []string{
+		"",
+		"00-" + testTraceID + "-" + testSpanID,
+		"00-" + testTraceID + "-" + testSpanID + "-01-extra",
+		"ff-" + testTraceID + "-" + testSpanID + "-01",
+		"00-00000000000000000000000000000000-" + testSpanID + "-01",
+		"00-" + testTraceID + "-0000000000000000-01",
+	} {
+		if _, ok := ParseTraceparent(s); ok {
+			t.Fatalf("ParseTraceparent(%q) accepted", s)
+		}
+	}
+}
+
+func TestFindTraceparent(t *testing.T) {
+	tp := "00-" + testTraceID + "-" + testSpanID + "-00"
+	for _, line := range []string{tp, "GET / traceparent=" + tp, "[" + tp + "] GET /", `{"traceparent":"` + tp + `"}`} {
+		sc, ok := FindTraceparent(line)
+		if !ok || sc.TraceID().String() != testTraceID || sc.IsSampled() {
+			t.Fatalf("FindTraceparent(%q)=%v %v", line, sc, ok)
+		}
+	}
+	for _, line := range []string{"no trace here", "a" + tp, tp + "0", "id " + testTraceID} {
+		if _, ok := FindTraceparent(line); ok {
+			t.Fatalf("FindTraceparent(%q) accepted", line)
+		}
+	}
+}